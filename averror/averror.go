@@ -0,0 +1,123 @@
+// Package averror wrap FFmpeg AVERROR codes into Go errors
+package averror
+
+import (
+	"errors"
+	"io"
+	"syscall"
+
+	"github.com/xueqing/goav/libavutil"
+)
+
+// AVERROR_xxx codes
+const (
+	CodeAgain            = -int(syscall.EAGAIN)
+	CodeInvalidArg       = -int(syscall.EINVAL)
+	CodeNoMem            = -int(syscall.ENOMEM)
+	CodeIO               = -int(syscall.EIO)
+	CodeEOF              = -('E' | 'O'<<8 | 'F'<<16 | ' '<<24)
+	CodeExit             = -('E' | 'X'<<8 | 'I'<<16 | 'T'<<24)
+	CodeInvalidData      = -('I' | 'N'<<8 | 'D'<<16 | 'A'<<24)
+	CodeBug              = -('B' | 'U'<<8 | 'G'<<16 | '!'<<24)
+	CodeBufferTooSmall   = -('B' | 'U'<<8 | 'F'<<16 | 'S'<<24)
+	CodeExternal         = -('E' | 'X'<<8 | 'T'<<16 | ' '<<24)
+	CodePatchWelcome     = -('P' | 'A'<<8 | 'W'<<16 | 'E'<<24)
+	CodeUnknown          = -('U' | 'N'<<8 | 'K'<<16 | 'N'<<24)
+	CodeBSFNotFound      = -(0xF8 | 'B'<<8 | 'S'<<16 | 'F'<<24)
+	CodeDecoderNotFound  = -(0xF8 | 'D'<<8 | 'E'<<16 | 'C'<<24)
+	CodeDemuxerNotFound  = -(0xF8 | 'D'<<8 | 'E'<<16 | 'M'<<24)
+	CodeEncoderNotFound  = -(0xF8 | 'E'<<8 | 'N'<<16 | 'C'<<24)
+	CodeFilterNotFound   = -(0xF8 | 'F'<<8 | 'I'<<16 | 'L'<<24)
+	CodeMuxerNotFound    = -(0xF8 | 'M'<<8 | 'U'<<16 | 'X'<<24)
+	CodeOptionNotFound   = -(0xF8 | 'O'<<8 | 'P'<<16 | 'T'<<24)
+	CodeProtocolNotFound = -(0xF8 | 'P'<<8 | 'R'<<16 | 'O'<<24)
+	CodeStreamNotFound   = -(0xF8 | 'S'<<8 | 'T'<<16 | 'R'<<24)
+)
+
+// sentinels to compare with errors.Is
+var (
+	ErrAgain            = &AVError{Code: CodeAgain}
+	ErrInvalidArg       = &AVError{Code: CodeInvalidArg}
+	ErrNoMem            = &AVError{Code: CodeNoMem}
+	ErrIO               = &AVError{Code: CodeIO}
+	ErrEOF              = &AVError{Code: CodeEOF}
+	ErrExit             = &AVError{Code: CodeExit}
+	ErrInvalidData      = &AVError{Code: CodeInvalidData}
+	ErrBug              = &AVError{Code: CodeBug}
+	ErrBufferTooSmall   = &AVError{Code: CodeBufferTooSmall}
+	ErrExternal         = &AVError{Code: CodeExternal}
+	ErrPatchWelcome     = &AVError{Code: CodePatchWelcome}
+	ErrUnknown          = &AVError{Code: CodeUnknown}
+	ErrBSFNotFound      = &AVError{Code: CodeBSFNotFound}
+	ErrDecoderNotFound  = &AVError{Code: CodeDecoderNotFound}
+	ErrDemuxerNotFound  = &AVError{Code: CodeDemuxerNotFound}
+	ErrEncoderNotFound  = &AVError{Code: CodeEncoderNotFound}
+	ErrFilterNotFound   = &AVError{Code: CodeFilterNotFound}
+	ErrMuxerNotFound    = &AVError{Code: CodeMuxerNotFound}
+	ErrOptionNotFound   = &AVError{Code: CodeOptionNotFound}
+	ErrProtocolNotFound = &AVError{Code: CodeProtocolNotFound}
+	ErrStreamNotFound   = &AVError{Code: CodeStreamNotFound}
+)
+
+// AVError is an error returned by a libav* function
+type AVError struct {
+	// Op describe the failed operation, e.g. "Decoder Send"
+	Op string
+	// Code is the negative AVERROR code
+	Code int
+	// Err is the underlying cause if known, e.g. context error of an
+	// interrupted operation or error of a custom reader
+	Err error
+}
+
+// New Return an AVError for code, nil if code is not negative
+func New(op string, code int) error {
+	if code >= 0 {
+		return nil
+	}
+	return &AVError{Op: op, Code: code}
+}
+
+// Wrap Return an AVError for code caused by err, nil if code is not negative
+func Wrap(op string, code int, err error) error {
+	if code >= 0 {
+		return nil
+	}
+	return &AVError{Op: op, Code: code, Err: err}
+}
+
+// Error describe the error like av_err2str, with Op as prefix
+func (e *AVError) Error() string {
+	msg := libavutil.ErrorFromCode(e.Code).Error()
+	if e.Err != nil {
+		msg = e.Err.Error()
+	}
+	if len(e.Op) == 0 {
+		return msg
+	}
+	return e.Op + ": error(" + msg + ")"
+}
+
+// Unwrap Return the underlying cause
+func (e *AVError) Unwrap() error {
+	return e.Err
+}
+
+// Is report whether target is a sentinel with the same code.
+// AVERROR_EOF also matches io.EOF
+func (e *AVError) Is(target error) bool {
+	if target == io.EOF {
+		return e.Code == CodeEOF
+	}
+	t, ok := target.(*AVError)
+	return ok && len(t.Op) == 0 && t.Code == e.Code
+}
+
+// Code Return the AVERROR code of err, 0 if err is not an AVError
+func Code(err error) int {
+	var e *AVError
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+#include "avio.h"
+#include "_cgo_export.h"
+
+static int interrupt_cb(void *opaque)
+{
+    return avioInterruptCallback((uintptr_t)opaque);
+}
+
+static int read_packet(void *opaque, uint8_t *buf, int buf_size)
+{
+    return avioReadPacket((uintptr_t)opaque, buf, buf_size);
+}
+
+static int write_packet(void *opaque, uint8_t *buf, int buf_size)
+{
+    return avioWritePacket((uintptr_t)opaque, buf, buf_size);
+}
+
+static int64_t seek(void *opaque, int64_t offset, int whence)
+{
+    return avioSeek((uintptr_t)opaque, offset, whence);
+}
+
+void avio_set_interrupt_cb(AVFormatContext *s, uintptr_t handle)
+{
+    s->interrupt_callback.callback = interrupt_cb;
+    s->interrupt_callback.opaque = (void *)handle;
+}
+
+AVIOContext *avio_alloc_reader_context(uintptr_t handle, int buf_size, int seekable)
+{
+    AVIOContext *pb;
+    unsigned char *buf = av_malloc(buf_size);
+    if (!buf)
+        return NULL;
+    pb = avio_alloc_context(buf, buf_size, 0, (void *)handle,
+                            read_packet, NULL, seekable ? seek : NULL);
+    if (!pb)
+        av_free(buf);
+    return pb;
+}
+
+AVIOContext *avio_alloc_writer_context(uintptr_t handle, int buf_size, int seekable)
+{
+    AVIOContext *pb;
+    unsigned char *buf = av_malloc(buf_size);
+    if (!buf)
+        return NULL;
+    pb = avio_alloc_context(buf, buf_size, 1, (void *)handle,
+                            NULL, write_packet, seekable ? seek : NULL);
+    if (!pb)
+        av_free(buf);
+    return pb;
+}
+
+void avio_free_context(AVIOContext **pb)
+{
+    // the buffer may be reallocated by libavformat, free the current one
+    if (*pb)
+        av_freep(&(*pb)->buffer);
+    avio_context_free(pb);
+}
@@ -0,0 +1,19 @@
+#ifndef FFMPEG_DEMO_AVIO_H
+#define FFMPEG_DEMO_AVIO_H
+
+#include <stdint.h>
+#include <libavformat/avformat.h>
+
+// install interrupt callback of s, handle is passed back to Go as opaque
+void avio_set_interrupt_cb(AVFormatContext *s, uintptr_t handle);
+
+// allocate a read AVIOContext calling back to Go, seek callback is set if seekable
+AVIOContext *avio_alloc_reader_context(uintptr_t handle, int buf_size, int seekable);
+
+// allocate a write AVIOContext calling back to Go, seek callback is set if seekable
+AVIOContext *avio_alloc_writer_context(uintptr_t handle, int buf_size, int seekable);
+
+// free AVIOContext allocated by avio_alloc_xxx_context and its buffer
+void avio_free_context(AVIOContext **pb);
+
+#endif
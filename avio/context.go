@@ -0,0 +1,144 @@
+package avio
+
+//#cgo pkg-config: libavformat libavutil
+//#include "avio.h"
+import "C"
+import (
+	"fmt"
+	"io"
+	"unsafe"
+
+	"github.com/xueqing/goav/libavformat"
+)
+
+// bufferSize is the size of internal buffer of AVIOContext
+const bufferSize = 32 * 1024
+
+// maxEmptyReads is the number of consecutive 0, nil reads before read fail, as bufio does
+const maxEmptyReads = 100
+
+// Context wrap Go reader/writer into a custom AVIOContext
+type Context struct {
+	pIOCtx *C.AVIOContext
+	handle uintptr
+
+	r io.Reader
+	w io.Writer
+	s io.Seeker
+	// last error returned by r/w/s
+	err error
+}
+
+// NewReader create a read Context on r, seek is enabled when r implements io.Seeker
+func NewReader(r io.Reader) (c *Context, err error) {
+	c = &Context{r: r}
+	if s, ok := r.(io.Seeker); ok {
+		c.s = s
+	}
+	c.handle = newHandle(c)
+
+	if c.pIOCtx = C.avio_alloc_reader_context(C.uintptr_t(c.handle), bufferSize, boolToCInt(c.s != nil)); c.pIOCtx == nil {
+		c.Close()
+		err = fmt.Errorf("avio NewReader: alloc context error")
+		return nil, err
+	}
+	return
+}
+
+// NewWriter create a write Context on w, seek is enabled when w implements io.WriteSeeker
+// (e.g. needed by mp4 muxer to rewrite moov atom)
+func NewWriter(w io.Writer) (c *Context, err error) {
+	c = &Context{w: w}
+	if s, ok := w.(io.WriteSeeker); ok {
+		c.s = s
+	}
+	c.handle = newHandle(c)
+
+	if c.pIOCtx = C.avio_alloc_writer_context(C.uintptr_t(c.handle), bufferSize, boolToCInt(c.s != nil)); c.pIOCtx == nil {
+		c.Close()
+		err = fmt.Errorf("avio NewWriter: alloc context error")
+		return nil, err
+	}
+	return
+}
+
+// Seekable Return whether seek callback is set
+func (c *Context) Seekable() bool {
+	return c.s != nil
+}
+
+// AvIOContext Return the AVIOContext to set as pb of AVFormatContext
+func (c *Context) AvIOContext() *libavformat.AvIOContext {
+	return (*libavformat.AvIOContext)(unsafe.Pointer(c.pIOCtx))
+}
+
+// Err Return last error of the underlying reader/writer
+func (c *Context) Err() error {
+	return c.err
+}
+
+// Flush write data buffered in AVIOContext to the writer
+func (c *Context) Flush() {
+	if c.pIOCtx != nil {
+		C.avio_flush(c.pIOCtx)
+	}
+}
+
+// Close free AVIOContext, must be called after the AVFormatContext using it is closed
+func (c *Context) Close() {
+	if c.pIOCtx != nil {
+		C.avio_free_context(&c.pIOCtx)
+	}
+	if c.handle != 0 {
+		deleteHandle(c.handle)
+		c.handle = 0
+	}
+}
+
+func (c *Context) read(buf []byte) (n int, err error) {
+	// io.Reader may return 0, nil; libavformat expects data or error
+	for i := 0; i < maxEmptyReads; i++ {
+		if n, err = c.r.Read(buf); n > 0 {
+			return n, nil
+		}
+		if err != nil {
+			return
+		}
+	}
+	return 0, io.ErrNoProgress
+}
+
+func (c *Context) write(buf []byte) (n int, err error) {
+	n, err = c.w.Write(buf)
+	if err == nil && n < len(buf) {
+		err = io.ErrShortWrite
+	}
+	return
+}
+
+func (c *Context) seek(offset int64, whence int) (pos int64, err error) {
+	if c.s == nil {
+		return -1, fmt.Errorf("avio seek: not seekable")
+	}
+	if whence != avseekSize {
+		return c.s.Seek(offset, whence)
+	}
+
+	// Return the stream size without moving
+	var cur int64
+	if cur, err = c.s.Seek(0, io.SeekCurrent); err != nil {
+		return
+	}
+	if pos, err = c.s.Seek(0, io.SeekEnd); err != nil {
+		return
+	}
+	_, err = c.s.Seek(cur, io.SeekStart)
+	return
+}
+
+func boolToCInt(b bool) C.int {
+	if b {
+		return 1
+	}
+	return 0
+}
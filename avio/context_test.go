@@ -0,0 +1,99 @@
+package avio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// emptyReader return 0, nil for empty reads before reading r
+type emptyReader struct {
+	empty int
+	r     io.Reader
+}
+
+func (e *emptyReader) Read(p []byte) (int, error) {
+	if e.empty > 0 {
+		e.empty--
+		return 0, nil
+	}
+	if e.r == nil {
+		return 0, nil
+	}
+	return e.r.Read(p)
+}
+
+func TestNewReader(t *testing.T) {
+	c, err := NewReader(bytes.NewReader([]byte("0123456789")))
+	if err != nil {
+		t.Fatalf("NewReader error(%v)", err)
+	}
+	defer c.Close()
+	if !c.Seekable() || c.AvIOContext() == nil {
+		t.Fatalf("bytes.Reader context seekable(%v) AVIOContext(%v)", c.Seekable(), c.AvIOContext())
+	}
+
+	buf := make([]byte, 4)
+	if n, err := c.read(buf); n != 4 || err != nil || string(buf) != "0123" {
+		t.Errorf("read return %q, %v", buf[:n], err)
+	}
+	// AVSEEK_SIZE return the size without moving
+	if size, err := c.seek(0, avseekSize); size != 10 || err != nil {
+		t.Errorf("seek size return %v, %v", size, err)
+	}
+	if n, err := c.read(buf); n != 4 || err != nil || string(buf) != "4567" {
+		t.Errorf("read after seek size return %q, %v", buf[:n], err)
+	}
+	if pos, err := c.seek(8, io.SeekStart); pos != 8 || err != nil {
+		t.Errorf("seek return %v, %v", pos, err)
+	}
+	if n, err := c.read(buf); n != 2 || err != nil || string(buf[:n]) != "89" {
+		t.Errorf("read after seek return %q, %v", buf[:n], err)
+	}
+	if n, err := c.read(buf); n != 0 || err != io.EOF {
+		t.Errorf("read at end return %v, %v", n, err)
+	}
+
+	// closing twice is a no-op
+	c.Close()
+	if c.AvIOContext() != nil {
+		t.Error("AVIOContext is not freed")
+	}
+}
+
+func TestNewReaderNotSeekable(t *testing.T) {
+	c, err := NewReader(struct{ io.Reader }{bytes.NewReader([]byte("data"))})
+	if err != nil {
+		t.Fatalf("NewReader error(%v)", err)
+	}
+	defer c.Close()
+	if c.Seekable() {
+		t.Error("reader without Seek is seekable")
+	}
+	if _, err = c.seek(0, io.SeekStart); err == nil {
+		t.Error("seek of not seekable reader succeed")
+	}
+}
+
+func TestReadEmpty(t *testing.T) {
+	buf := make([]byte, 4)
+	c, err := NewReader(&emptyReader{empty: 3, r: bytes.NewReader([]byte("data"))})
+	if err != nil {
+		t.Fatalf("NewReader error(%v)", err)
+	}
+	defer c.Close()
+	if n, err := c.read(buf); n != 4 || err != nil {
+		t.Errorf("read after empty reads return %v, %v", n, err)
+	}
+
+	// a reader never returning data must not block libavformat forever
+	c2, err := NewReader(&emptyReader{})
+	if err != nil {
+		t.Fatalf("NewReader error(%v)", err)
+	}
+	defer c2.Close()
+	if n, err := c2.read(buf); n != 0 || !errors.Is(err, io.ErrNoProgress) {
+		t.Errorf("read of empty reader return %v, %v", n, err)
+	}
+}
@@ -0,0 +1,83 @@
+package avio
+
+//#include <errno.h>
+//#include <stdint.h>
+import "C"
+import (
+	"io"
+	"unsafe"
+
+	"github.com/xueqing/goav/libavutil"
+)
+
+// AVSEEK_xxx
+const (
+	avseekSize  = 0x10000
+	avseekForce = 0x20000
+)
+
+// avioInterruptCallback is called by libavformat during blocking operations,
+// return 1 to abort the operation
+//
+//export avioInterruptCallback
+func avioInterruptCallback(opaque C.uintptr_t) C.int {
+	i, ok := handleValue(uintptr(opaque)).(*Interrupter)
+	if !ok || i.ctx.Err() == nil {
+		return 0
+	}
+	return 1
+}
+
+// avioReadPacket fill buf from the Go reader
+//
+//export avioReadPacket
+func avioReadPacket(opaque C.uintptr_t, buf *C.uint8_t, size C.int) C.int {
+	c, ok := handleValue(uintptr(opaque)).(*Context)
+	if !ok || c.r == nil {
+		return C.int(-C.EINVAL)
+	}
+	n, err := c.read((*[1 << 30]byte)(unsafe.Pointer(buf))[:size:size])
+	if err == io.EOF {
+		return libavutil.AvErrorEOF
+	}
+	if err != nil {
+		c.err = err
+		return C.int(-C.EIO)
+	}
+	return C.int(n)
+}
+
+// avioWritePacket write buf to the Go writer
+//
+//export avioWritePacket
+func avioWritePacket(opaque C.uintptr_t, buf *C.uint8_t, size C.int) C.int {
+	c, ok := handleValue(uintptr(opaque)).(*Context)
+	if !ok || c.w == nil {
+		return C.int(-C.EINVAL)
+	}
+	if size <= 0 {
+		return 0
+	}
+	n, err := c.write((*[1 << 30]byte)(unsafe.Pointer(buf))[:size:size])
+	if err != nil {
+		c.err = err
+		return C.int(-C.EIO)
+	}
+	return C.int(n)
+}
+
+// avioSeek seek the Go reader/writer, whence is one of io.SeekXXX or AVSEEK_SIZE
+//
+//export avioSeek
+func avioSeek(opaque C.uintptr_t, offset C.int64_t, whence C.int) C.int64_t {
+	c, ok := handleValue(uintptr(opaque)).(*Context)
+	if !ok {
+		return C.int64_t(-C.EINVAL)
+	}
+	pos, err := c.seek(int64(offset), int(whence)&^avseekForce)
+	if err != nil {
+		c.err = err
+		return C.int64_t(-C.EIO)
+	}
+	return C.int64_t(pos)
+}
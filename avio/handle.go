@@ -0,0 +1,34 @@
+package avio
+
+import "sync"
+
+// handles keep Go values referenced from C by an integer handle,
+// since Go pointers can not be stored in C memory
+var (
+	handleMu   sync.Mutex
+	handleNext uintptr
+	handles    = make(map[uintptr]interface{})
+)
+
+// newHandle save v and return a non-zero handle
+func newHandle(v interface{}) uintptr {
+	handleMu.Lock()
+	defer handleMu.Unlock()
+	handleNext++
+	handles[handleNext] = v
+	return handleNext
+}
+
+// handleValue return value saved by newHandle, nil if handle is deleted
+func handleValue(h uintptr) interface{} {
+	handleMu.Lock()
+	defer handleMu.Unlock()
+	return handles[h]
+}
+
+// deleteHandle release value saved by newHandle
+func deleteHandle(h uintptr) {
+	handleMu.Lock()
+	defer handleMu.Unlock()
+	delete(handles, h)
+}
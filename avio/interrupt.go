@@ -0,0 +1,48 @@
+// Package avio connect libavformat I/O with Go
+package avio
+
+//#cgo pkg-config: libavformat libavutil
+//#include "avio.h"
+import "C"
+import (
+	"context"
+	"unsafe"
+
+	"github.com/xueqing/goav/libavformat"
+)
+
+// Interrupter abort blocking libavformat operations when its context is done
+type Interrupter struct {
+	ctx    context.Context
+	handle uintptr
+}
+
+// NewInterrupter create a Interrupter watching ctx
+func NewInterrupter(ctx context.Context) *Interrupter {
+	i := &Interrupter{ctx: ctx}
+	i.handle = newHandle(i)
+	return i
+}
+
+// Context Return the watched context
+func (i *Interrupter) Context() context.Context {
+	return i.ctx
+}
+
+// Err Return ctx.Err()
+func (i *Interrupter) Err() error {
+	return i.ctx.Err()
+}
+
+// Install set interrupt callback of pFmtCtx. The Interrupter must outlive pFmtCtx
+func (i *Interrupter) Install(pFmtCtx *libavformat.AvFormatContext) {
+	C.avio_set_interrupt_cb((*C.AVFormatContext)(unsafe.Pointer(pFmtCtx)), C.uintptr_t(i.handle))
+}
+
+// Close release the handle, callback installed before will not interrupt anymore
+func (i *Interrupter) Close() {
+	if i.handle != 0 {
+		deleteHandle(i.handle)
+		i.handle = 0
+	}
+}
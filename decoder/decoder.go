@@ -1,10 +1,13 @@
 package decoder
 
 import (
+	"errors"
 	"fmt"
 	"unsafe"
 
-	"github.com/google/logger"
+	"github.com/xueqing/ffmpeg-demo/averror"
+	"github.com/xueqing/ffmpeg-demo/logutil"
+	"github.com/xueqing/ffmpeg-demo/media"
 	"github.com/xueqing/goav/libavcodec"
 	"github.com/xueqing/goav/libavformat"
 	"github.com/xueqing/goav/libavutil"
@@ -12,13 +15,17 @@ import (
 
 // Decoder decode AVPacket to AVFrame
 type Decoder struct {
-	// must call libavutil.AvFrameFree(pFrame) after use
-	FrameHandler func(pFrame *libavutil.AvFrame) (err error)
+	// frame is freed after handler returns, call frame.Clone() to keep it
+	FrameHandler func(frame *media.Frame) (err error)
+	// structured logger, logutil.Default() is used when nil
+	Logger *logutil.Logger
 
 	pInFmtCtx *libavformat.AvFormatContext
 	pDecCtx   *libavcodec.AvCodecContext
 	mediaType libavutil.AvMediaType
 	streamIdx int
+	// time base of packets and decoded frames, same as the input stream
+	timeBase libavcodec.AvRational
 }
 
 // New create a Decoder
@@ -34,11 +41,24 @@ func (d *Decoder) DecCodecContext() *libavcodec.AvCodecContext {
 	return d.pDecCtx
 }
 
+// TimeBase Return time base of packets sent to decoder and decoded frames
+func (d *Decoder) TimeBase() libavcodec.AvRational {
+	return d.timeBase
+}
+
 // StreamIdx Return streamIdx
 func (d *Decoder) StreamIdx() int {
 	return d.streamIdx
 }
 
+// log return Logger, or logutil.Default() if it is not set
+func (d *Decoder) log() *logutil.Logger {
+	if d.Logger != nil {
+		return d.Logger
+	}
+	return logutil.Default()
+}
+
 // Close ...
 func (d *Decoder) Close() {
 	if d.pDecCtx != nil {
@@ -62,38 +82,45 @@ func (d *Decoder) Open(pInStream *libavformat.AvStream) (err error) {
 	// Find a registered decoder with a matching codec ID.
 	pDec = libavcodec.AvcodecFindDecoder(codecID)
 	if pDec == nil {
-		err = fmt.Errorf("Decoder Open: find decoder by id(%v) error", libavcodec.AvcodecGetName(codecID))
+		err = averror.New(fmt.Sprintf("Decoder Open: find decoder by id(%v)", libavcodec.AvcodecGetName(codecID)),
+			averror.CodeDecoderNotFound)
 		return
 	}
 
 	// Allocate an AVCodecContext and set its fields to default values. The
 	// resulting struct should be freed with avcodec_free_context().
 	if d.pDecCtx = pDec.AvcodecAllocContext3(); d.pDecCtx == nil {
-		err = fmt.Errorf("Decoder Open: alloc context error")
+		err = averror.New("Decoder Open: alloc context", averror.CodeNoMem)
 		return
 	}
 
 	// copy decoder parameters to decoder context
 	if ret := d.pDecCtx.AvcodecParametersToContext(pInStream.CodecParameters()); ret < 0 {
-		err = fmt.Errorf("Decoder Open: copy decoder parameters to decoder context error(%v)", libavutil.ErrorFromCode(ret))
+		err = averror.New("Decoder Open: copy decoder parameters to decoder context", ret)
 		return
 	}
 
+	// packets are in the time base of input stream, frames are tagged with it
+	d.timeBase = pInStream.TimeBase()
+	setPktTimebase(d.pDecCtx, d.timeBase)
+
 	// open decoder
 	d.mediaType = libavutil.AvMediaType(pInStream.CodecParameters().CodecType())
 	if d.mediaType == libavutil.AvmediaTypeVideo {
 		d.pDecCtx.SetFramerate(d.pInFmtCtx.AvGuessFrameRate(pInStream, nil))
 	}
 	if ret := d.pDecCtx.AvcodecOpen2(pDec, nil); ret < 0 {
-		err = fmt.Errorf("Decoder Open: open decoder error(%v)", libavutil.ErrorFromCode(ret))
+		err = averror.New("Decoder Open: open decoder", ret)
 		return
 	}
 
 	d.streamIdx = pInStream.Index()
+	d.log().Debug("Decoder Open", logutil.F("stream", d.streamIdx), logutil.F("codec", libavcodec.AvcodecGetName(codecID)))
 	return
 }
 
-// Send Supply raw packet data as input to a decoder.
+// Send Supply raw packet data as input to a decoder, timestamps must be in the time base
+// of the input stream as returned by Demuxer.
 func (d *Decoder) Send(pPkt *libavcodec.AvPacket) (err error) {
 	if d.pDecCtx == nil {
 		err = fmt.Errorf("Decoder Send: codec context is nil")
@@ -113,25 +140,28 @@ func (d *Decoder) Send(pPkt *libavcodec.AvPacket) (err error) {
 	 *      other errors: legitimate decoding errors
 	 */
 	if ret := d.pDecCtx.AvcodecSendPacket(pPkt); ret < 0 {
-		err = fmt.Errorf("Decoder Send: error(%v)", libavutil.ErrorFromCode(ret))
+		err = averror.New("Decoder Send", ret)
 		return
 	}
 	return
 }
 
-// Receive Return decoded output data from a decoder.
-func (d *Decoder) Receive() (pFrame *libavutil.AvFrame, err error) {
+// Receive Return decoded output data from a decoder, tagged with the time base of the input
+// stream. pts is set to the best effort timestamp.
+// Return nil frame when more input is needed or the decoder is fully flushed.
+// Must call frame.Free() after use
+func (d *Decoder) Receive() (frame *media.Frame, err error) {
 	if d.pDecCtx == nil {
 		err = fmt.Errorf("Decoder Receive: codec context is nil")
 		return
 	}
 
-	if pFrame = libavutil.AvFrameAlloc(); pFrame == nil {
-		err = fmt.Errorf("Decoder Receive: failed to alloc memory for frame")
+	if frame = media.NewFrame(); frame == nil {
+		err = averror.New("Decoder Receive: alloc frame", averror.CodeNoMem)
 		return
 	}
 
-	pFrameConvert := (*libavcodec.AvFrame)(unsafe.Pointer(pFrame))
+	pFrameConvert := (*libavcodec.AvFrame)(unsafe.Pointer(frame.AvFrame()))
 	/*
 	 * @return
 	 *      0:                 success, a frame was returned
@@ -147,38 +177,82 @@ func (d *Decoder) Receive() (pFrame *libavutil.AvFrame, err error) {
 		goto end
 	}
 	if ret < 0 {
-		err = fmt.Errorf("Decoder Receive: error(%v)", libavutil.ErrorFromCode(ret))
+		err = averror.New("Decoder Receive", ret)
 		goto end
 	}
+	// best effort timestamp is more reliable than pts with B-frames or broken inputs
+	frame.SetPts(frame.AvFrame().BestEffortTimestamp())
+	frame.SetTimeBase(d.timeBase)
 	return
 
 end:
-	libavutil.AvFrameFree(pFrame)
-	pFrame = nil
+	frame.Free()
+	frame = nil
 	return
 }
 
-// Decode Decode packet to frame
+// Decode Decode packet to frame, decoded frames are passed to FrameHandler.
+// If the decoder does not accept the packet, buffered frames are received first and
+// the packet is sent again
 func (d *Decoder) Decode(pPkt *libavcodec.AvPacket) (err error) {
-	if err = d.Send(pPkt); err != nil {
-		logger.Errorf("Decoder Decode: Send error(%v)", err)
+	for {
+		if err = d.Send(pPkt); err == nil {
+			break
+		}
+		if !errors.Is(err, averror.ErrAgain) {
+			d.log().Error("Decoder Decode: Send", logutil.F("stream", d.streamIdx), logutil.F("error", err))
+			return
+		}
+		var n int
+		if n, err = d.receiveAll(); err != nil {
+			return
+		}
+		if n == 0 {
+			err = fmt.Errorf("Decoder Decode: send returns EAGAIN but no frame is available")
+			return
+		}
+	}
+	_, err = d.receiveAll()
+	return
+}
+
+// Flush drain all buffered frames through FrameHandler, call Reset before decoding again
+func (d *Decoder) Flush() (err error) {
+	// a nil packet enters draining mode, sending it again returns AVERROR_EOF
+	if err = d.Send(nil); err != nil && !errors.Is(err, averror.ErrEOF) {
 		return
 	}
+	_, err = d.receiveAll()
+	return
+}
 
-	var pFrame *libavutil.AvFrame
+// Reset discard buffered frames and reset the decoder, e.g. after seeking or Flush
+func (d *Decoder) Reset() {
+	if d.pDecCtx != nil {
+		d.pDecCtx.AvcodecFlushBuffers()
+	}
+}
+
+// receiveAll pass frames to FrameHandler until more input is needed or
+// the decoder is fully flushed, return the number of frames
+func (d *Decoder) receiveAll() (n int, err error) {
+	var frame *media.Frame
 	for {
-		pFrame, err = d.Receive()
+		frame, err = d.Receive()
 		if err != nil {
-			logger.Errorf("Decoder Decode: Receive error(%v)", err)
+			d.log().Error("Decoder Decode: Receive", logutil.F("stream", d.streamIdx), logutil.F("error", err))
 			return
 		}
-		if pFrame == nil {
+		if frame == nil {
 			return
 		}
+		n++
 		if d.FrameHandler != nil {
-			if err = d.FrameHandler(pFrame); err != nil {
-				return
-			}
+			err = d.FrameHandler(frame)
+		}
+		frame.Free()
+		if err != nil {
+			return
 		}
 	}
 }
@@ -0,0 +1,16 @@
+package decoder
+
+//#cgo pkg-config: libavcodec
+//#include <libavcodec/avcodec.h>
+import "C"
+import (
+	"unsafe"
+
+	"github.com/xueqing/goav/libavcodec"
+)
+
+// setPktTimebase set AVCodecContext.pkt_timebase, the time base of packets sent to decoder
+func setPktTimebase(pDecCtx *libavcodec.AvCodecContext, tb libavcodec.AvRational) {
+	pCtx := (*C.AVCodecContext)(unsafe.Pointer(pDecCtx))
+	pCtx.pkt_timebase = C.AVRational{num: C.int(tb.Num()), den: C.int(tb.Den())}
+}
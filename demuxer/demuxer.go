@@ -1,9 +1,16 @@
 package demuxer
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"unsafe"
 
+	"github.com/xueqing/ffmpeg-demo/averror"
+	"github.com/xueqing/ffmpeg-demo/avio"
+	"github.com/xueqing/ffmpeg-demo/logutil"
+	"github.com/xueqing/ffmpeg-demo/media"
+	"github.com/xueqing/ffmpeg-demo/util"
 	"github.com/xueqing/goav/libavcodec"
 	"github.com/xueqing/goav/libavformat"
 	"github.com/xueqing/goav/libavutil"
@@ -11,7 +18,14 @@ import (
 
 // Demuxer demux container to get packets
 type Demuxer struct {
-	pInFmtCtx *libavformat.AvFormatContext
+	// dump information about input onto standard error after open
+	DumpFormat bool
+	// structured logger, logutil.Default() is used when nil
+	Logger *logutil.Logger
+
+	pInFmtCtx   *libavformat.AvFormatContext
+	interrupter *avio.Interrupter
+	ioCtx       *avio.Context
 }
 
 // New init a demuxer
@@ -24,6 +38,14 @@ func (d *Demuxer) InFormatContext() *libavformat.AvFormatContext {
 	return d.pInFmtCtx
 }
 
+// log return Logger, or logutil.Default() if it is not set
+func (d *Demuxer) log() *logutil.Logger {
+	if d.Logger != nil {
+		return d.Logger
+	}
+	return logutil.Default()
+}
+
 // Close release some memory
 func (d *Demuxer) Close() {
 	// Close an opened input AVFormatContext. Free it and all its contents
@@ -31,10 +53,29 @@ func (d *Demuxer) Close() {
 		d.pInFmtCtx.AvformatCloseInput()
 		d.pInFmtCtx = nil
 	}
+	if d.interrupter != nil {
+		d.interrupter.Close()
+		d.interrupter = nil
+	}
+	// Custom AVIOContext is not freed by avformat_close_input
+	if d.ioCtx != nil {
+		d.ioCtx.Close()
+		d.ioCtx = nil
+	}
 }
 
 // Open initlize format context
 func (d *Demuxer) Open(strURL, strFmt string) (err error) {
+	_, err = d.OpenWithOptions(context.Background(), strURL, strFmt, nil)
+	return
+}
+
+// OpenWithOptions initlize format context with format/protocol options (e.g. probesize,
+// analyzeduration, rw_timeout, fflags), options not consumed by them are passed to the
+// decoders probing streams. Cancelling ctx aborts open, probing and ReadPacket.
+// Return keys of options not consumed by the input format, protocol or decoders
+func (d *Demuxer) OpenWithOptions(ctx context.Context, strURL, strFmt string,
+	options map[string]interface{}) (unused []string, err error) {
 	if d.pInFmtCtx != nil {
 		err = fmt.Errorf("Demuxer Open: input format context is not nil")
 		return
@@ -44,30 +85,88 @@ func (d *Demuxer) Open(strURL, strFmt string) (err error) {
 	var pInFmt *libavformat.AvInputFormat
 	if len(strFmt) != 0 {
 		if pInFmt = libavformat.AvFindInputFormat(strFmt); pInFmt == nil {
-			err = fmt.Errorf("Demuxer Open: find input format(%v) error", strFmt)
+			err = averror.New(fmt.Sprintf("Demuxer Open: find input format(%v)", strFmt), averror.CodeDemuxerNotFound)
 			return
 		}
 	}
 
+	var pDict *libavutil.AvDictionary
+	if pDict, err = util.GetAVDictionaryFromMap(options); err != nil {
+		return
+	}
+	// open and probing free the dictionary and replace it with the unused entries
+	defer func() { pDict.AvDictFree() }()
+
+	// Allocate context in advance to install the interrupt callback before open.
+	if d.pInFmtCtx = libavformat.AvformatAllocContext(); d.pInFmtCtx == nil {
+		err = averror.New("Demuxer Open: alloc format context", averror.CodeNoMem)
+		return
+	}
+	if ctx.Done() != nil {
+		d.interrupter = avio.NewInterrupter(ctx)
+		d.interrupter.Install(d.pInFmtCtx)
+	}
+
+	if d.ioCtx != nil {
+		d.pInFmtCtx.SetPb(d.ioCtx.AvIOContext())
+	}
+
 	// Open an input stream and read the header. The codecs are not opened.
 	// The stream must be closed with avformat_close_input().
-	if ret := libavformat.AvformatOpenInput(&d.pInFmtCtx, strURL, pInFmt, nil); ret < 0 {
-		err = fmt.Errorf("Demuxer Open: open input(%v) error(%v)", strURL, libavutil.ErrorFromCode(ret))
+	// On failure, the context is freed and set to nil.
+	if ret := libavformat.AvformatOpenInput(&d.pInFmtCtx, strURL, pInFmt,
+		(**libavutil.AvDictionary)(unsafe.Pointer(&pDict))); ret < 0 {
+		err = d.wrapError(fmt.Sprintf("Demuxer Open: open input(%v)", strURL), ret)
+		d.Close()
 		return
 	}
 
 	// Read packets of a media file to get stream information.
-	if ret := d.pInFmtCtx.AvformatFindStreamInfo(nil); ret != 0 {
-		err = fmt.Errorf("Demuxer Open: failed to faind stream info error(%v)", libavutil.ErrorFromCode(ret))
+	if ret := findStreamInfo(d.pInFmtCtx, &pDict); ret < 0 {
+		err = d.wrapError("Demuxer Open: find stream info", ret)
+		d.Close()
 		return
 	}
+	// Entries left in the dictionary are not found by the format, protocol or decoders.
+	unused = util.GetKeysFromAVDictionary(pDict)
 
 	// Dump information about file onto standard error
-	d.pInFmtCtx.AvDumpFormat(0, strURL, 0)
+	if d.DumpFormat {
+		d.pInFmtCtx.AvDumpFormat(0, strURL, 0)
+	}
+	d.log().Debug("Demuxer Open", logutil.F("url", strURL), logutil.F("streams", d.pInFmtCtx.NbStreams()))
+
+	return
+}
 
+// OpenReader initlize format context reading from r instead of a url.
+// Seeking is supported when r implements io.Seeker. strFmt can be empty to probe the format
+func (d *Demuxer) OpenReader(r io.Reader, strFmt string) (err error) {
+	if d.pInFmtCtx != nil {
+		err = fmt.Errorf("Demuxer OpenReader: input format context is not nil")
+		return
+	}
+	if d.ioCtx, err = avio.NewReader(r); err != nil {
+		return
+	}
+	if _, err = d.OpenWithOptions(context.Background(), "", strFmt, nil); err != nil {
+		d.Close()
+	}
 	return
 }
 
+// wrapError Return AVError of ret, caused by context error if the operation is aborted
+// by the interrupter, or by the error of custom reader
+func (d *Demuxer) wrapError(op string, ret int) error {
+	if d.interrupter != nil && d.interrupter.Err() != nil {
+		return averror.Wrap(op, ret, d.interrupter.Err())
+	}
+	if d.ioCtx != nil && d.ioCtx.Err() != nil {
+		return averror.Wrap(op, ret, d.ioCtx.Err())
+	}
+	return averror.New(op, ret)
+}
+
 // Streams get streams
 func (d *Demuxer) Streams() ([]*libavformat.AvStream, error) {
 	if d.pInFmtCtx == nil {
@@ -76,20 +175,39 @@ func (d *Demuxer) Streams() ([]*libavformat.AvStream, error) {
 	return d.pInFmtCtx.Streams(), nil
 }
 
-// ReadPacket get a packet
+// ReadPacket get a packet, packets of streams discarded by SelectStreams are skipped.
+// Return averror.ErrEOF (which also matches io.EOF by errors.Is) at end of input
 func (d *Demuxer) ReadPacket(pPkt *libavcodec.AvPacket) (err error) {
 	if d.pInFmtCtx == nil {
 		err = fmt.Errorf("Demuxer ReadPacket: input format context is nil")
 		return
 	}
-	// Return the next frame of a stream.
-	if ret := d.pInFmtCtx.AvReadFrame(pPkt); ret < 0 {
-		if ret == libavutil.AvErrorEOF {
-			err = io.EOF
-		} else {
-			err = fmt.Errorf("Demuxer ReadPacket: Read frame error(%v)", libavutil.ErrorFromCode(ret))
+	for {
+		// Return the next frame of a stream.
+		if ret := d.pInFmtCtx.AvReadFrame(pPkt); ret < 0 {
+			err = d.wrapError("Demuxer ReadPacket", ret)
+			return
+		}
+		// Some demuxers still return packets of discarded streams
+		if !d.isDiscarded(pPkt.StreamIndex()) {
+			return
 		}
+		pPkt.AvPacketUnref()
+	}
+}
+
+// Read get the next packet tagged with the time base of its stream.
+// Must call pkt.Free() after use
+func (d *Demuxer) Read() (pkt *media.Packet, err error) {
+	if pkt = media.NewPacket(); pkt == nil {
+		err = averror.New("Demuxer Read: alloc packet", averror.CodeNoMem)
+		return
+	}
+	if err = d.ReadPacket(pkt.AvPacket()); err != nil {
+		pkt.Free()
+		pkt = nil
 		return
 	}
+	pkt.SetTimeBase(d.pInFmtCtx.Streams()[pkt.StreamIndex()].TimeBase())
 	return
 }
@@ -0,0 +1,244 @@
+package demuxer
+
+//#cgo pkg-config: libavformat libavcodec libavutil
+//#include <libavformat/avformat.h>
+//#include <libavutil/channel_layout.h>
+//#include <libavutil/pixdesc.h>
+//#include <libavutil/samplefmt.h>
+import "C"
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/xueqing/ffmpeg-demo/util"
+	"github.com/xueqing/goav/libavcodec"
+	"github.com/xueqing/goav/libavformat"
+	"github.com/xueqing/goav/libavutil"
+)
+
+// MediaInfo describe an input like ffprobe, times are in seconds
+type MediaInfo struct {
+	Format   FormatInfo    `json:"format"`
+	Streams  []StreamInfo  `json:"streams"`
+	Chapters []ChapterInfo `json:"chapters,omitempty"`
+	Programs []ProgramInfo `json:"programs,omitempty"`
+}
+
+// FormatInfo describe the container
+type FormatInfo struct {
+	URL       string            `json:"url,omitempty"`
+	Name      string            `json:"format_name"`
+	LongName  string            `json:"format_long_name,omitempty"`
+	NbStreams int               `json:"nb_streams"`
+	StartTime float64           `json:"start_time"`
+	Duration  float64           `json:"duration"`
+	BitRate   int64             `json:"bit_rate,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+}
+
+// StreamInfo describe a stream, video or audio fields are empty for other media types
+type StreamInfo struct {
+	Index       int               `json:"index"`
+	ID          int               `json:"id"`
+	CodecType   string            `json:"codec_type"`
+	CodecName   string            `json:"codec_name"`
+	Profile     string            `json:"profile,omitempty"`
+	Level       int               `json:"level,omitempty"`
+	BitRate     int64             `json:"bit_rate,omitempty"`
+	TimeBase    string            `json:"time_base"`
+	StartTime   float64           `json:"start_time"`
+	Duration    float64           `json:"duration"`
+	NbFrames    int64             `json:"nb_frames,omitempty"`
+	Language    string            `json:"language,omitempty"`
+	Disposition []string          `json:"disposition,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+
+	// video
+	Width             int    `json:"width,omitempty"`
+	Height            int    `json:"height,omitempty"`
+	PixelFormat       string `json:"pix_fmt,omitempty"`
+	SampleAspectRatio string `json:"sample_aspect_ratio,omitempty"`
+	FrameRate         string `json:"r_frame_rate,omitempty"`
+	AvgFrameRate      string `json:"avg_frame_rate,omitempty"`
+
+	// audio
+	SampleFormat  string `json:"sample_fmt,omitempty"`
+	SampleRate    int    `json:"sample_rate,omitempty"`
+	Channels      int    `json:"channels,omitempty"`
+	ChannelLayout string `json:"channel_layout,omitempty"`
+}
+
+// ChapterInfo describe a chapter
+type ChapterInfo struct {
+	ID        int64             `json:"id"`
+	StartTime float64           `json:"start_time"`
+	EndTime   float64           `json:"end_time"`
+	Title     string            `json:"title,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+}
+
+// ProgramInfo describe a program, e.g. of mpegts
+type ProgramInfo struct {
+	ID         int               `json:"program_id"`
+	ProgramNum int               `json:"program_num"`
+	PmtPid     int               `json:"pmt_pid"`
+	Streams    []int             `json:"streams"`
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+// dispositions AV_DISPOSITION_xxx and their names
+var dispositions = []struct {
+	flag int
+	name string
+}{
+	{C.AV_DISPOSITION_DEFAULT, "default"},
+	{C.AV_DISPOSITION_DUB, "dub"},
+	{C.AV_DISPOSITION_ORIGINAL, "original"},
+	{C.AV_DISPOSITION_COMMENT, "comment"},
+	{C.AV_DISPOSITION_LYRICS, "lyrics"},
+	{C.AV_DISPOSITION_KARAOKE, "karaoke"},
+	{C.AV_DISPOSITION_FORCED, "forced"},
+	{C.AV_DISPOSITION_HEARING_IMPAIRED, "hearing_impaired"},
+	{C.AV_DISPOSITION_VISUAL_IMPAIRED, "visual_impaired"},
+	{C.AV_DISPOSITION_CLEAN_EFFECTS, "clean_effects"},
+	{C.AV_DISPOSITION_ATTACHED_PIC, "attached_pic"},
+	{C.AV_DISPOSITION_TIMED_THUMBNAILS, "timed_thumbnails"},
+	{C.AV_DISPOSITION_CAPTIONS, "captions"},
+	{C.AV_DISPOSITION_DESCRIPTIONS, "descriptions"},
+	{C.AV_DISPOSITION_METADATA, "metadata"},
+}
+
+var avTimeBaseQ = libavcodec.NewAvRational(1, util.AvTimeBase)
+
+// Probe open url and return its MediaInfo without dumping onto standard error
+func Probe(strURL string) (info *MediaInfo, err error) {
+	d := New()
+	defer d.Close()
+	if err = d.Open(strURL, ""); err != nil {
+		return
+	}
+	if info, err = d.MediaInfo(); err != nil {
+		return
+	}
+	info.Format.URL = strURL
+	return
+}
+
+// MediaInfo Return MediaInfo of the opened input
+func (d *Demuxer) MediaInfo() (info *MediaInfo, err error) {
+	if d.pInFmtCtx == nil {
+		err = fmt.Errorf("Demuxer MediaInfo: input format context is nil")
+		return
+	}
+	pCtx := (*C.AVFormatContext)(unsafe.Pointer(d.pInFmtCtx))
+
+	info = &MediaInfo{
+		Format: FormatInfo{
+			Name:      C.GoString(pCtx.iformat.name),
+			LongName:  C.GoString(pCtx.iformat.long_name),
+			NbStreams: int(pCtx.nb_streams),
+			StartTime: tsToSeconds(int64(pCtx.start_time), avTimeBaseQ),
+			Duration:  tsToSeconds(int64(pCtx.duration), avTimeBaseQ),
+			BitRate:   int64(pCtx.bit_rate),
+			Tags:      util.GetMapFromAVDictionary(d.pInFmtCtx.Metadata()),
+		},
+	}
+
+	for _, st := range d.pInFmtCtx.Streams() {
+		info.Streams = append(info.Streams, streamInfo(st))
+	}
+
+	nbChapters := int(pCtx.nb_chapters)
+	if nbChapters > 0 {
+		chapters := (*[1 << 20]*C.AVChapter)(unsafe.Pointer(pCtx.chapters))[:nbChapters:nbChapters]
+		for _, ch := range chapters {
+			tb := libavcodec.NewAvRational(int(ch.time_base.num), int(ch.time_base.den))
+			c := ChapterInfo{
+				ID:        int64(ch.id),
+				StartTime: tsToSeconds(int64(ch.start), tb),
+				EndTime:   tsToSeconds(int64(ch.end), tb),
+				Tags:      util.GetMapFromAVDictionary((*libavutil.AvDictionary)(unsafe.Pointer(ch.metadata))),
+			}
+			c.Title = c.Tags["title"]
+			info.Chapters = append(info.Chapters, c)
+		}
+	}
+
+	for _, prog := range d.pInFmtCtx.Programs() {
+		pProg := (*C.AVProgram)(unsafe.Pointer(prog))
+		p := ProgramInfo{
+			ID:         int(pProg.id),
+			ProgramNum: int(pProg.program_num),
+			PmtPid:     int(pProg.pmt_pid),
+			Tags:       util.GetMapFromAVDictionary((*libavutil.AvDictionary)(unsafe.Pointer(pProg.metadata))),
+		}
+		if nb := int(pProg.nb_stream_indexes); nb > 0 {
+			for _, idx := range (*[1 << 20]C.uint)(unsafe.Pointer(pProg.stream_index))[:nb:nb] {
+				p.Streams = append(p.Streams, int(idx))
+			}
+		}
+		info.Programs = append(info.Programs, p)
+	}
+	return
+}
+
+func streamInfo(st *libavformat.AvStream) (s StreamInfo) {
+	par := (*C.AVCodecParameters)(unsafe.Pointer(st.CodecParameters()))
+	tb := st.TimeBase()
+
+	s = StreamInfo{
+		Index:     st.Index(),
+		ID:        st.ID(),
+		CodecType: libavutil.AvGetMediaTypeString(libavutil.AvMediaType(par.codec_type)),
+		CodecName: libavcodec.AvcodecGetName(libavcodec.AvCodecID(par.codec_id)),
+		Level:     int(par.level),
+		BitRate:   int64(par.bit_rate),
+		TimeBase:  tb.String(),
+		StartTime: tsToSeconds(st.StartTime(), tb),
+		Duration:  tsToSeconds(st.Duration(), tb),
+		NbFrames:  st.NbFrames(),
+		Tags:      util.GetMapFromAVDictionary(st.Metadata()),
+	}
+	if profile := C.avcodec_profile_name(par.codec_id, par.profile); profile != nil {
+		s.Profile = C.GoString(profile)
+	}
+	s.Language = s.Tags["language"]
+	for _, disp := range dispositions {
+		if st.Disposition()&disp.flag != 0 {
+			s.Disposition = append(s.Disposition, disp.name)
+		}
+	}
+
+	switch par.codec_type {
+	case C.AVMEDIA_TYPE_VIDEO:
+		s.Width, s.Height = int(par.width), int(par.height)
+		if name := C.av_get_pix_fmt_name(C.enum_AVPixelFormat(par.format)); name != nil {
+			s.PixelFormat = C.GoString(name)
+		}
+		if sar := st.SampleAspectRatio(); sar.Num() != 0 {
+			s.SampleAspectRatio = fmt.Sprintf("%d:%d", sar.Num(), sar.Den())
+		}
+		s.FrameRate = st.RFrameRate().String()
+		s.AvgFrameRate = st.AvgFrameRate().String()
+	case C.AVMEDIA_TYPE_AUDIO:
+		s.SampleRate = int(par.sample_rate)
+		s.Channels = int(par.channels)
+		if name := C.av_get_sample_fmt_name(C.enum_AVSampleFormat(par.format)); name != nil {
+			s.SampleFormat = C.GoString(name)
+		}
+		if par.channel_layout != 0 {
+			var buf [64]C.char
+			C.av_get_channel_layout_string(&buf[0], C.int(len(buf)), par.channels, par.channel_layout)
+			s.ChannelLayout = C.GoString(&buf[0])
+		}
+	}
+	return
+}
+
+// tsToSeconds convert ts in time base tb to seconds, 0 if ts is AV_NOPTS_VALUE
+func tsToSeconds(ts int64, tb libavcodec.AvRational) float64 {
+	if ts == util.AvNoPtsValue {
+		return 0
+	}
+	return util.TsToDuration(ts, tb).Seconds()
+}
@@ -0,0 +1,168 @@
+package demuxer_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/xueqing/ffmpeg-demo/demuxer"
+	"github.com/xueqing/ffmpeg-demo/encoder"
+	"github.com/xueqing/ffmpeg-demo/media"
+	"github.com/xueqing/ffmpeg-demo/muxer"
+	"github.com/xueqing/goav/libavcodec"
+	"github.com/xueqing/goav/libavutil"
+)
+
+const (
+	sampleRate = 8000
+	// AV_SAMPLE_FMT_S16 and AV_CH_LAYOUT_MONO, not exported by goav
+	sampleFmtS16      = libavcodec.AvSampleFormat(1)
+	channelLayoutMono = 0x4
+	// 1s of audio in frames of 100ms
+	nbFrames  = 10
+	nbSamples = sampleRate / nbFrames
+)
+
+// writeWav encode 1s of silence to pcm_s16le and mux it into a wav in memory
+func writeWav(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+
+	enc := encoder.New()
+	defer enc.Close()
+	err := enc.Open(encoder.Params{
+		MediaType:     libavutil.AvmediaTypeAudio,
+		CodecID:       libavcodec.AvCodecID(libavcodec.AvCodecIDPcmS16le),
+		SampleRate:    sampleRate,
+		SampleFormat:  sampleFmtS16,
+		ChannelLayout: channelLayoutMono,
+	}, nil)
+	if err != nil {
+		t.Fatalf("encoder Open error(%v)", err)
+	}
+
+	mux := muxer.New()
+	defer mux.Close()
+	if err = mux.OpenWriter(&buf, "wav"); err != nil {
+		t.Fatalf("muxer OpenWriter error(%v)", err)
+	}
+	if _, err = mux.AddStreamFromEncoder(enc); err != nil {
+		t.Fatalf("muxer AddStreamFromEncoder error(%v)", err)
+	}
+	if err = mux.WriteHeader(nil); err != nil {
+		t.Fatalf("muxer WriteHeader error(%v)", err)
+	}
+	enc.PacketHandler = mux.Write
+
+	for i := 0; i < nbFrames; i++ {
+		frame, err := media.NewAudioFrame(nbSamples, sampleFmtS16, sampleRate, channelLayoutMono)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plane := frame.Plane(0)
+		for j := range plane {
+			plane[j] = 0
+		}
+		frame.SetPts(int64(i * nbSamples))
+		frame.SetTimeBase(libavcodec.NewAvRational(1, sampleRate))
+		err = enc.Encode(frame.AvFrame())
+		frame.Free()
+		if err != nil {
+			t.Fatalf("encoder Encode error(%v)", err)
+		}
+	}
+	if err = enc.Flush(); err != nil {
+		t.Fatalf("encoder Flush error(%v)", err)
+	}
+	if ret := mux.WriteTrailer(); ret < 0 {
+		t.Fatalf("muxer WriteTrailer error(%v)", ret)
+	}
+	return buf.Bytes()
+}
+
+// readAll read packets until EOF, return the payload size and the first pts
+func readAll(t *testing.T, d *demuxer.Demuxer) (size int, first time.Duration) {
+	t.Helper()
+	first = media.NoPts
+	for {
+		pkt, err := d.Read()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.Fatalf("demuxer Read error(%v)", err)
+			}
+			return
+		}
+		if first == media.NoPts {
+			first = pkt.Pts()
+		}
+		size += pkt.Size()
+		pkt.Free()
+	}
+}
+
+func TestOpenReader(t *testing.T) {
+	data := writeWav(t)
+	if len(data) == 0 {
+		t.Fatal("nothing is written")
+	}
+
+	d := demuxer.New()
+	defer d.Close()
+	if err := d.OpenReader(bytes.NewReader(data), "wav"); err != nil {
+		t.Fatalf("demuxer OpenReader error(%v)", err)
+	}
+	streams, err := d.Streams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 1 {
+		t.Fatalf("streams(%v), want 1", len(streams))
+	}
+	par := streams[0].CodecParameters()
+	if par.CodecID() != libavcodec.AvCodecID(libavcodec.AvCodecIDPcmS16le) {
+		t.Errorf("codec(%v), want pcm_s16le", libavcodec.AvcodecGetName(par.CodecID()))
+	}
+
+	pkt := media.NewPacket()
+	if err = d.ReadPacket(pkt.AvPacket()); err != nil {
+		t.Fatalf("demuxer ReadPacket error(%v)", err)
+	}
+	if pkt.StreamIndex() != 0 || pkt.Size() == 0 {
+		t.Errorf("packet of stream(%v) size(%v)", pkt.StreamIndex(), pkt.Size())
+	}
+	size := pkt.Size()
+	pkt.Free()
+	rest, _ := readAll(t, d)
+	if size+rest != sampleRate*2 {
+		t.Errorf("read %v bytes, want %v", size+rest, sampleRate*2)
+	}
+
+	// bytes.Reader implements io.Seeker
+	if err = d.Seek(0, 500*time.Millisecond, demuxer.SeekBackward); err != nil {
+		t.Fatalf("demuxer Seek error(%v)", err)
+	}
+	rest, first := readAll(t, d)
+	if first < 400*time.Millisecond || first > 500*time.Millisecond {
+		t.Errorf("first pts(%v) after seek, want about 500ms", first)
+	}
+	if want := int(sampleRate*2*(time.Second-first)) / int(time.Second); rest != want {
+		t.Errorf("read %v bytes after seek, want %v", rest, want)
+	}
+}
+
+func TestOpenReaderNotSeekable(t *testing.T) {
+	data := writeWav(t)
+
+	d := demuxer.New()
+	defer d.Close()
+	// hide Seek of bytes.Reader
+	r := struct{ io.Reader }{bytes.NewReader(data)}
+	if err := d.OpenReader(r, "wav"); err != nil {
+		t.Fatalf("demuxer OpenReader error(%v)", err)
+	}
+	if size, first := readAll(t, d); size != sampleRate*2 || first != 0 {
+		t.Errorf("read %v bytes from pts(%v), want %v from 0", size, first, sampleRate*2)
+	}
+}
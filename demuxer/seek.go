@@ -0,0 +1,150 @@
+package demuxer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/xueqing/ffmpeg-demo/util"
+	"github.com/xueqing/goav/libavcodec"
+	"github.com/xueqing/goav/libavformat"
+)
+
+// SeekFlags AVSEEK_FLAG_xxx
+type SeekFlags int
+
+// seek flags can be combined, e.g. SeekBackward|SeekAny
+const (
+	// SeekBackward seek to the nearest position before ts
+	SeekBackward SeekFlags = libavformat.AvseekFlagBackward
+	// SeekByte ts is a byte position of the input
+	SeekByte SeekFlags = libavformat.AvseekFlagByte
+	// SeekAny seek to any frame, even non-keyframes
+	SeekAny SeekFlags = libavformat.AvseekFlagAny
+	// SeekFrame ts is a frame number of the stream
+	SeekFrame SeekFlags = libavformat.AvseekFlagFrame
+)
+
+// Seek seek to ts of stream streamIdx, or of default time base if streamIdx is -1.
+// ts is relative to the start time of the input.
+// With SeekByte or SeekFrame int64(ts) is used as byte position or frame number
+func (d *Demuxer) Seek(streamIdx int, ts time.Duration, flags SeekFlags) (err error) {
+	if d.pInFmtCtx == nil {
+		err = fmt.Errorf("Demuxer Seek: input format context is nil")
+		return
+	}
+
+	var target int64
+	if flags&(SeekByte|SeekFrame) != 0 {
+		target = int64(ts)
+	} else {
+		if target, err = d.durationToTs(streamIdx, ts); err != nil {
+			return
+		}
+	}
+
+	// Seek to timestamp ts. Seeking will be done so that the point from which all
+	// active streams can be presented successfully will be closest to ts and
+	// within min_ts/max_ts.
+	minTs, maxTs := target, int64(math.MaxInt64)
+	if flags&SeekBackward != 0 {
+		minTs, maxTs = math.MinInt64, target
+	}
+	if ret := d.pInFmtCtx.AvformatSeekFile(streamIdx, minTs, target, maxTs, int(flags&^SeekBackward)); ret < 0 {
+		err = d.wrapError(fmt.Sprintf("Demuxer Seek: seek stream(%v) to %v", streamIdx, ts), ret)
+		return
+	}
+	return
+}
+
+// ReadRange seek backward to start, then read packets and call handler until packets of
+// every selected stream reach end, or EOF. end <= 0 means read until EOF.
+// Packets before start may be returned since seeking lands on a keyframe, packets of a
+// stream after it reaches end are skipped.
+// pPkt is unreferenced after handler returns, call AvPacketRef to keep it
+func (d *Demuxer) ReadRange(start, end time.Duration,
+	handler func(pPkt *libavcodec.AvPacket) error) (err error) {
+	if err = d.Seek(-1, start, SeekBackward); err != nil {
+		return
+	}
+
+	iStreams, err := d.Streams()
+	if err != nil {
+		return
+	}
+	// selected streams not reaching end yet
+	active := make(map[int]bool)
+	for _, idx := range d.SelectedStreams() {
+		active[idx] = true
+	}
+	pPkt := libavcodec.AvPacketAlloc()
+	defer util.AvPacketFree(pPkt)
+	for {
+		if err = d.ReadPacket(pPkt); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+			return
+		}
+
+		if end > 0 {
+			idx := pPkt.StreamIndex()
+			if idx >= len(iStreams) {
+				// stream added while reading, e.g. by inputs of AVFMTCTX_NOHEADER
+				if iStreams, err = d.Streams(); err != nil {
+					pPkt.AvPacketUnref()
+					return
+				}
+			}
+			// use dts which is monotonic, fall back to pts
+			ts := pPkt.Dts()
+			if ts == util.AvNoPtsValue {
+				ts = pPkt.Pts()
+			}
+			if ts != util.AvNoPtsValue && d.tsToDuration(iStreams[idx], ts) >= end {
+				pPkt.AvPacketUnref()
+				delete(active, idx)
+				if len(active) == 0 {
+					return
+				}
+				continue
+			}
+		}
+
+		if handler != nil {
+			err = handler(pPkt)
+		}
+		pPkt.AvPacketUnref()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// durationToTs convert ts relative to input start time to timestamp of stream streamIdx
+func (d *Demuxer) durationToTs(streamIdx int, ts time.Duration) (int64, error) {
+	startTime := d.pInFmtCtx.StartTime()
+	if startTime != util.AvNoPtsValue {
+		ts += time.Duration(startTime) * time.Microsecond
+	}
+	if streamIdx < 0 {
+		return util.DurationToTs(ts, libavcodec.NewAvRational(1, util.AvTimeBase)), nil
+	}
+
+	iStreams := d.pInFmtCtx.Streams()
+	if streamIdx >= len(iStreams) {
+		return 0, fmt.Errorf("Demuxer Seek: invalid stream index(%v)", streamIdx)
+	}
+	return util.DurationToTs(ts, iStreams[streamIdx].TimeBase()), nil
+}
+
+// tsToDuration convert timestamp of st to duration relative to input start time
+func (d *Demuxer) tsToDuration(st *libavformat.AvStream, ts int64) time.Duration {
+	dur := util.TsToDuration(ts, st.TimeBase())
+	if startTime := d.pInFmtCtx.StartTime(); startTime != util.AvNoPtsValue {
+		dur -= time.Duration(startTime) * time.Microsecond
+	}
+	return dur
+}
@@ -0,0 +1,123 @@
+package demuxer
+
+import (
+	"fmt"
+
+	"github.com/xueqing/goav/libavformat"
+	"github.com/xueqing/goav/libavutil"
+)
+
+// AVDISCARD_xxx
+const (
+	avdiscardDefault = 0
+	avdiscardAll     = 48
+)
+
+// StreamSelector Return indexes of selected streams of d
+type StreamSelector func(d *Demuxer) ([]int, error)
+
+// BestStream select the "best" stream of mediaType by av_find_best_stream,
+// audio/subtitle streams related to the selected video stream are preferred.
+// It selects nothing when no such stream exists
+func BestStream(mediaType libavutil.AvMediaType) StreamSelector {
+	return func(d *Demuxer) ([]int, error) {
+		relatedStream := -1
+		if mediaType != libavutil.AvmediaTypeVideo {
+			relatedStream = d.pInFmtCtx.AvFindBestStream(libavutil.AvmediaTypeVideo, -1, -1, nil, 0)
+		}
+		idx := d.pInFmtCtx.AvFindBestStream(libavformat.AvMediaType(mediaType), -1, relatedStream, nil, 0)
+		if idx < 0 {
+			return nil, nil
+		}
+		return []int{idx}, nil
+	}
+}
+
+// StreamIndexes select streams by index
+func StreamIndexes(indexes ...int) StreamSelector {
+	return func(d *Demuxer) ([]int, error) {
+		nbStreams := int(d.pInFmtCtx.NbStreams())
+		for _, idx := range indexes {
+			if idx < 0 || idx >= nbStreams {
+				return nil, fmt.Errorf("Demuxer SelectStreams: invalid stream index(%v)", idx)
+			}
+		}
+		return indexes, nil
+	}
+}
+
+// StreamLanguage select streams whose "language" metadata tag is lang (e.g. eng)
+func StreamLanguage(lang string) StreamSelector {
+	return func(d *Demuxer) (indexes []int, err error) {
+		for _, st := range d.pInFmtCtx.Streams() {
+			if entry := st.Metadata().AvDictGet("language", nil, 0); entry != nil && entry.Value() == lang {
+				indexes = append(indexes, st.Index())
+			}
+		}
+		return
+	}
+}
+
+// StreamMediaType select all streams of mediaType
+func StreamMediaType(mediaType libavutil.AvMediaType) StreamSelector {
+	return func(d *Demuxer) (indexes []int, err error) {
+		for _, st := range d.pInFmtCtx.Streams() {
+			if libavutil.AvMediaType(st.CodecParameters().CodecType()) == mediaType {
+				indexes = append(indexes, st.Index())
+			}
+		}
+		return
+	}
+}
+
+// SelectStreams keep streams matched by any selector and discard the others,
+// packets of discarded streams are not read nor returned by ReadPacket.
+// Calling it again replaces the previous selection.
+// Return indexes of selected streams in ascending order
+func (d *Demuxer) SelectStreams(selectors ...StreamSelector) (selected []int, err error) {
+	if d.pInFmtCtx == nil {
+		err = fmt.Errorf("Demuxer SelectStreams: input format context is nil")
+		return
+	}
+
+	iStreams := d.pInFmtCtx.Streams()
+	keep := make([]bool, len(iStreams))
+	for _, selector := range selectors {
+		var indexes []int
+		if indexes, err = selector(d); err != nil {
+			return
+		}
+		for _, idx := range indexes {
+			keep[idx] = true
+		}
+	}
+
+	for idx, st := range iStreams {
+		if keep[idx] {
+			st.SetDiscard(avdiscardDefault)
+			selected = append(selected, idx)
+		} else {
+			st.SetDiscard(avdiscardAll)
+		}
+	}
+	return
+}
+
+// SelectedStreams Return indexes of streams not discarded
+func (d *Demuxer) SelectedStreams() (selected []int) {
+	if d.pInFmtCtx == nil {
+		return
+	}
+	for idx, st := range d.pInFmtCtx.Streams() {
+		if st.Discard() != avdiscardAll {
+			selected = append(selected, idx)
+		}
+	}
+	return
+}
+
+// isDiscarded Return whether packets of stream idx should be dropped
+func (d *Demuxer) isDiscarded(idx int) bool {
+	iStreams := d.pInFmtCtx.Streams()
+	return idx < 0 || idx >= len(iStreams) || iStreams[idx].Discard() == avdiscardAll
+}
@@ -0,0 +1,51 @@
+package demuxer
+
+//#cgo pkg-config: libavformat libavutil
+//#include <libavformat/avformat.h>
+//#include <libavutil/dict.h>
+//#include <libavutil/mem.h>
+//
+//// find_stream_info probe streams with a copy of *opts as decoder options of each stream,
+//// *opts is replaced by entries consumed by none of the streams
+//static int find_stream_info(AVFormatContext *s, AVDictionary **opts)
+//{
+//    unsigned int i, n = s->nb_streams;
+//    AVDictionary **sopts = NULL, *left = NULL;
+//    AVDictionaryEntry *e = NULL;
+//    int ret;
+//
+//    if (!*opts || !n)
+//        return avformat_find_stream_info(s, NULL);
+//    if (!(sopts = av_calloc(n, sizeof(*sopts))))
+//        return AVERROR(ENOMEM);
+//    for (i = 0; i < n; i++)
+//        av_dict_copy(&sopts[i], *opts, 0);
+//    ret = avformat_find_stream_info(s, sopts);
+//    while ((e = av_dict_get(*opts, "", e, AV_DICT_IGNORE_SUFFIX))) {
+//        for (i = 0; i < n && av_dict_get(sopts[i], e->key, NULL, 0); i++)
+//            ;
+//        if (i == n)
+//            av_dict_set(&left, e->key, e->value, 0);
+//    }
+//    for (i = 0; i < n; i++)
+//        av_dict_free(&sopts[i]);
+//    av_freep(&sopts);
+//    av_dict_free(opts);
+//    *opts = left;
+//    return ret;
+//}
+import "C"
+import (
+	"unsafe"
+
+	"github.com/xueqing/goav/libavformat"
+	"github.com/xueqing/goav/libavutil"
+)
+
+// findStreamInfo read packets to get stream information, options left by the input format
+// are passed to the decoders used for probing (e.g. threads). *ppDict is replaced by
+// the options consumed by none of the streams
+func findStreamInfo(pFmtCtx *libavformat.AvFormatContext, ppDict **libavutil.AvDictionary) int {
+	return int(C.find_stream_info((*C.AVFormatContext)(unsafe.Pointer(pFmtCtx)),
+		(**C.AVDictionary)(unsafe.Pointer(ppDict))))
+}
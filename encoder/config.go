@@ -0,0 +1,224 @@
+package encoder
+
+//#cgo pkg-config: libavcodec libavutil
+//#include <stdlib.h>
+//#include <libavcodec/avcodec.h>
+//#include <libavutil/pixdesc.h>
+//#include <libavutil/samplefmt.h>
+import "C"
+import (
+	"fmt"
+	"strconv"
+	"unsafe"
+
+	"github.com/xueqing/goav/libavcodec"
+)
+
+// EncoderConfig select the encoder and its rate-control options.
+// Zero values and nil pointers keep the codec defaults
+type EncoderConfig struct {
+	// CodecName is the encoder name, e.g. libx264, aac, libopus.
+	// Empty select the default encoder of the input codec
+	CodecName string
+
+	// BitRate is the average bit rate in bit/s
+	BitRate int64
+	// MaxRate is the maximum bit rate in bit/s, requires BufSize
+	MaxRate int64
+	// BufSize is the rate control buffer size in bits
+	BufSize int
+	// CRF is the constant rate factor, e.g. 23 for libx264
+	CRF *float64
+	// QP is the constant quantizer
+	QP *int
+
+	// GopSize is the maximum distance between keyframes in frames
+	GopSize int
+	// MaxBFrames is the maximum number of consecutive B-frames, 0 disable B-frames
+	MaxBFrames *int
+
+	// Profile, e.g. high, main, aac_low
+	Profile string
+	// Level, e.g. 4.1
+	Level string
+	// Preset, e.g. veryfast
+	Preset string
+	// Tune, e.g. zerolatency
+	Tune string
+
+	// Threads is the thread count, 0 let the codec decide
+	Threads int
+
+	// PixelFormat is the video pixel format name, e.g. yuv420p
+	PixelFormat string
+	// SampleFormat is the audio sample format name, e.g. fltp
+	SampleFormat string
+
+	// Options are private options of the encoder, e.g. x264-params, applied
+	// after the fields above. Values are formatted by util.OptionValue: integers, floats,
+	// bool, time.Duration, string and util.Flags
+	Options map[string]interface{}
+}
+
+// Int return a pointer to v, for optional config fields
+func Int(v int) *int {
+	return &v
+}
+
+// Float return a pointer to v, for optional config fields
+func Float(v float64) *float64 {
+	return &v
+}
+
+// FindEncoder find the encoder by cfg.CodecName, or by codecID if cfg is nil or the name is empty
+func (cfg *EncoderConfig) FindEncoder(codecID libavcodec.AvCodecID) (pEnc *libavcodec.AvCodec, err error) {
+	if cfg == nil || cfg.CodecName == "" {
+		if pEnc = libavcodec.AvcodecFindEncoder(codecID); pEnc == nil {
+			err = fmt.Errorf("find encoder by id(%v)", libavcodec.AvcodecGetName(codecID))
+		}
+		return
+	}
+	if pEnc = libavcodec.AvcodecFindEncoderByName(cfg.CodecName); pEnc == nil {
+		err = fmt.Errorf("find encoder by name(%v)", cfg.CodecName)
+	}
+	return
+}
+
+// apply set codec context fields and formats of cfg
+func (cfg *EncoderConfig) apply(pEncCtx *libavcodec.AvCodecContext) (err error) {
+	if cfg == nil {
+		return
+	}
+	ctx := (*C.AVCodecContext)(unsafe.Pointer(pEncCtx))
+	if cfg.BitRate > 0 {
+		ctx.bit_rate = C.int64_t(cfg.BitRate)
+	}
+	if cfg.MaxRate > 0 {
+		ctx.rc_max_rate = C.int64_t(cfg.MaxRate)
+	}
+	if cfg.BufSize > 0 {
+		ctx.rc_buffer_size = C.int(cfg.BufSize)
+	}
+	if cfg.GopSize > 0 {
+		ctx.gop_size = C.int(cfg.GopSize)
+	}
+	if cfg.MaxBFrames != nil {
+		ctx.max_b_frames = C.int(*cfg.MaxBFrames)
+	}
+	if cfg.Threads > 0 {
+		ctx.thread_count = C.int(cfg.Threads)
+	}
+	if cfg.PixelFormat != "" {
+		cName := C.CString(cfg.PixelFormat)
+		defer C.free(unsafe.Pointer(cName))
+		pixFmt := C.av_get_pix_fmt(cName)
+		if pixFmt == C.AV_PIX_FMT_NONE {
+			err = fmt.Errorf("unknown pixel format(%v)", cfg.PixelFormat)
+			return
+		}
+		ctx.pix_fmt = pixFmt
+	}
+	if cfg.SampleFormat != "" {
+		cName := C.CString(cfg.SampleFormat)
+		defer C.free(unsafe.Pointer(cName))
+		sampleFmt := C.av_get_sample_fmt(cName)
+		if sampleFmt == C.AV_SAMPLE_FMT_NONE {
+			err = fmt.Errorf("unknown sample format(%v)", cfg.SampleFormat)
+			return
+		}
+		ctx.sample_fmt = sampleFmt
+	}
+	return
+}
+
+// options return the encoder options passed to avcodec_open2
+func (cfg *EncoderConfig) options() map[string]interface{} {
+	if cfg == nil {
+		return nil
+	}
+	opts := make(map[string]interface{})
+	if cfg.CRF != nil {
+		opts["crf"] = strconv.FormatFloat(*cfg.CRF, 'f', -1, 64)
+	}
+	if cfg.QP != nil {
+		opts["qp"] = int64(*cfg.QP)
+	}
+	for k, v := range map[string]string{
+		"profile": cfg.Profile,
+		"level":   cfg.Level,
+		"preset":  cfg.Preset,
+		"tune":    cfg.Tune,
+	} {
+		if v != "" {
+			opts[k] = v
+		}
+	}
+	for k, v := range cfg.Options {
+		opts[k] = v
+	}
+	return opts
+}
+
+// checkFormats return error if the pixel or sample format of pEncCtx is not supported by pEnc
+func checkFormats(pEnc *libavcodec.AvCodec, pEncCtx *libavcodec.AvCodecContext) error {
+	ctx := (*C.AVCodecContext)(unsafe.Pointer(pEncCtx))
+	switch ctx.codec_type {
+	case C.AVMEDIA_TYPE_VIDEO:
+		pixFmts := pEnc.PixFmts()
+		if pixFmts == nil {
+			return nil
+		}
+		for _, f := range pixFmts {
+			if C.enum_AVPixelFormat(f) == ctx.pix_fmt {
+				return nil
+			}
+		}
+		return fmt.Errorf("pixel format(%v) is not supported by encoder(%v), supported(%v)",
+			pixFmtName(C.enum_AVPixelFormat(ctx.pix_fmt)), encoderName(pEnc), pixFmtNames(pixFmts))
+	case C.AVMEDIA_TYPE_AUDIO:
+		sampleFmts := pEnc.SampleFmts()
+		if sampleFmts == nil {
+			return nil
+		}
+		for _, f := range sampleFmts {
+			if C.enum_AVSampleFormat(f) == ctx.sample_fmt {
+				return nil
+			}
+		}
+		return fmt.Errorf("sample format(%v) is not supported by encoder(%v), supported(%v)",
+			sampleFmtName(C.enum_AVSampleFormat(ctx.sample_fmt)), encoderName(pEnc), sampleFmtNames(sampleFmts))
+	}
+	return nil
+}
+
+func encoderName(pEnc *libavcodec.AvCodec) string {
+	return C.GoString((*C.AVCodec)(unsafe.Pointer(pEnc)).name)
+}
+
+func pixFmtName(f C.enum_AVPixelFormat) string {
+	if name := C.av_get_pix_fmt_name(f); name != nil {
+		return C.GoString(name)
+	}
+	return strconv.Itoa(int(f))
+}
+
+func pixFmtNames(fmts []libavcodec.AvPixelFormat) (names []string) {
+	for _, f := range fmts {
+		names = append(names, pixFmtName(C.enum_AVPixelFormat(f)))
+	}
+	return
+}
+
+func sampleFmtName(f C.enum_AVSampleFormat) string {
+	if name := C.av_get_sample_fmt_name(f); name != nil {
+		return C.GoString(name)
+	}
+	return strconv.Itoa(int(f))
+}
+
+func sampleFmtNames(fmts []libavcodec.AvSampleFormat) (names []string) {
+	for _, f := range fmts {
+		names = append(names, sampleFmtName(C.enum_AVSampleFormat(f)))
+	}
+	return
+}
@@ -1,23 +1,28 @@
 package encoder
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"unsafe"
 
-	"github.com/google/logger"
+	"github.com/xueqing/ffmpeg-demo/averror"
+	"github.com/xueqing/ffmpeg-demo/logutil"
+	"github.com/xueqing/ffmpeg-demo/media"
+	"github.com/xueqing/ffmpeg-demo/util"
 	"github.com/xueqing/goav/libavcodec"
-	"github.com/xueqing/goav/libavformat"
 	"github.com/xueqing/goav/libavutil"
 )
 
 // Encoder encode AVFrame to AVPacket
 type Encoder struct {
-	// must call pPkt.AvPacketUnref() after use
-	PacketHandler func(pPkt *libavcodec.AvPacket) (err error)
+	// pkt is freed after handler returns, call pkt.Clone() to keep it
+	PacketHandler func(pkt *media.Packet) (err error)
+	// structured logger, logutil.Default() is used when nil
+	Logger *logutil.Logger
 
 	pEncCtx   *libavcodec.AvCodecContext
 	pEnc      *libavcodec.AvCodec
+	fifo      *audioFifo
 	mediaType libavutil.AvMediaType
 	streamIdx int
 }
@@ -44,34 +49,125 @@ func (e *Encoder) StreamIdx() int {
 	return e.streamIdx
 }
 
-// Close ...
-func (e *Encoder) Close() {
+// log return Logger, or logutil.Default() if it is not set
+func (e *Encoder) log() *logutil.Logger {
+	if e.Logger != nil {
+		return e.Logger
+	}
+	return logutil.Default()
+}
 
+// Close free the encoder context, the encoder can be opened again after Close
+func (e *Encoder) Close() {
+	if e.fifo != nil {
+		e.fifo.free()
+		e.fifo = nil
+	}
+	if e.pEncCtx != nil {
+		e.pEncCtx.AvcodecFreeContext()
+		e.pEncCtx = nil
+	}
+	e.pEnc = nil
+	e.mediaType = libavutil.AvmediaTypeUnknown
 }
 
-// Open ...
-func (e *Encoder) Open(pInStream *libavformat.AvStream) (err error) {
-	pDecCtx := (*libavcodec.AvCodecContext)(unsafe.Pointer(pInStream.Codec()))
-	codecID := libavcodec.AvCodecID(pDecCtx.CodecID())
-	// Find a registered encoder with a matching codec ID.
-	// in this example, we choose transcoding to same codec
-	if e.pEnc = libavcodec.AvcodecFindEncoder(codecID); e.pEnc == nil {
-		err = fmt.Errorf("Encoder Open: find encoder by id(%v) error", libavcodec.AvcodecGetName(codecID))
+// Open find the encoder selected by cfg, or by params.CodecID if cfg is nil or has no codec
+// name, set up the encoder context from params and cfg and open it.
+// Return error if the pixel or sample format is not supported by the encoder, or if any
+// config option is not recognized by it
+func (e *Encoder) Open(params Params, cfg *EncoderConfig) (err error) {
+	if e.pEncCtx != nil {
+		err = fmt.Errorf("Encoder Open: codec context is not nil")
+		return
+	}
+	// Find a registered encoder by name, or with a matching codec ID.
+	if e.pEnc, err = cfg.FindEncoder(params.CodecID); err != nil {
+		err = averror.Wrap("Encoder Open", averror.CodeEncoderNotFound, err)
 		return
 	}
 	// Allocate an AVCodecContext and set its fields to default values. The
 	// resulting struct should be freed with avcodec_free_context().
 	if e.pEncCtx = e.pEnc.AvcodecAllocContext3(); e.pEncCtx == nil {
-		err = fmt.Errorf("Encoder Open: alloc encoder context error")
+		err = averror.New("Encoder Open: alloc encoder context", averror.CodeNoMem)
+		return
+	}
+	if err = e.open(params, cfg); err != nil {
+		e.Close()
+		return
+	}
+	// Encoders such as AAC require exactly frame_size samples per frame
+	if e.fifo, err = newAudioFifo(e.pEnc, e.pEncCtx); err != nil {
+		e.Close()
+		return
+	}
+	e.mediaType = params.MediaType
+	e.streamIdx = params.StreamIndex
+	e.log().Debug("Encoder Open", logutil.F("stream", e.streamIdx), logutil.F("codec", encoderName(e.pEnc)))
+	return
+}
+
+func (e *Encoder) open(params Params, cfg *EncoderConfig) (err error) {
+	if err = params.apply(e.pEncCtx); err != nil {
+		err = fmt.Errorf("Encoder Open: %w", err)
+		return
+	}
+	if err = cfg.apply(e.pEncCtx); err != nil {
+		err = fmt.Errorf("Encoder Open: %w", err)
+		return
+	}
+	if err = checkFormats(e.pEnc, e.pEncCtx); err != nil {
+		err = fmt.Errorf("Encoder Open: %w", err)
+		return
+	}
+
+	var pDict *libavutil.AvDictionary
+	if pDict, err = util.GetAVDictionaryForObject(unsafe.Pointer(e.pEncCtx), cfg.options()); err != nil {
+		return
+	}
+	// avcodec_open2 free the dictionary and replace it with the unused entries
+	defer func() { pDict.AvDictFree() }()
+
+	if ret := e.pEncCtx.AvcodecOpen2(e.pEnc, (**libavcodec.AvDictionary)(unsafe.Pointer(&pDict))); ret < 0 {
+		err = averror.New(fmt.Sprintf("Encoder Open: open encoder(%v)", encoderName(e.pEnc)), ret)
+		return
+	}
+	// Entries left in the dictionary are not found by the encoder.
+	if unused := util.GetKeysFromAVDictionary(pDict); len(unused) > 0 {
+		err = averror.New(fmt.Sprintf("Encoder Open: options(%v) not found by encoder(%v)",
+			unused, encoderName(e.pEnc)), averror.CodeOptionNotFound)
+		return
+	}
+	return
+}
+
+// MediaType return media type of the opened encoder
+func (e *Encoder) MediaType() libavutil.AvMediaType {
+	return e.mediaType
+}
+
+// TimeBase return time base of encoded packets, the output stream should use it
+func (e *Encoder) TimeBase() libavcodec.AvRational {
+	if e.pEncCtx == nil {
+		return libavcodec.NewAvRational(0, 1)
+	}
+	return e.pEncCtx.TimeBase()
+}
+
+// CopyCodecParameters fill par (e.g. codecpar of an output stream) from the opened encoder
+func (e *Encoder) CopyCodecParameters(par *libavcodec.AvCodecParameters) (err error) {
+	if e.pEncCtx == nil {
+		err = fmt.Errorf("Encoder CopyCodecParameters: codec context is nil")
+		return
+	}
+	if ret := e.pEncCtx.AvcodecParametersFromContext(par); ret < 0 {
+		err = averror.New("Encoder CopyCodecParameters", ret)
 		return
 	}
-	e.mediaType = libavutil.AvMediaType(pInStream.CodecParameters().CodecType())
-	e.streamIdx = pInStream.Index()
 	return
 }
 
 // Send Supply a raw video or audio frame to the encoder.
-// Return io.EOF when ffmpeg return AVERROR(EAGAIN)/AVERROR_EOF
+// Return averror.ErrAgain when output must be received first, averror.ErrEOF when flushed
 func (e *Encoder) Send(pFrame *libavutil.AvFrame) (err error) {
 	if e.pEncCtx == nil {
 		err = fmt.Errorf("Encoder Send: codec context is nil")
@@ -93,29 +189,25 @@ func (e *Encoder) Send(pFrame *libavutil.AvFrame) (err error) {
 	 *      other errors: legitimate decoding errors
 	 */
 	if ret := e.pEncCtx.AvcodecSendFrame(pFrameConvert); ret < 0 {
-		if ret == libavutil.AvErrorEOF || ret == libavutil.AvErrorEAGAIN {
-			err = io.EOF
-		} else {
-			err = fmt.Errorf("Encoder Send: error(%v)", libavutil.ErrorFromCode(ret))
-		}
+		err = averror.New("Encoder Send", ret)
 		return
 	}
 	return
 }
 
-// Receive Read encoded data from the encoder.
-// Return io.EOF when ffmpeg return AVERROR(EAGAIN)/AVERROR_EOF
-func (e *Encoder) Receive() (pPkt *libavcodec.AvPacket, err error) {
+// Receive Read encoded data from the encoder, tagged with the time base of encoder.
+// Return averror.ErrAgain when more input is needed, averror.ErrEOF when fully flushed.
+// Must call pkt.Free() after use
+func (e *Encoder) Receive() (pkt *media.Packet, err error) {
 	if e.pEncCtx == nil {
 		err = fmt.Errorf("Encoder Receive: codec context is nil")
 		return
 	}
 
-	if pPkt = libavcodec.AvPacketAlloc(); pPkt == nil {
-		err = fmt.Errorf("Encoder Receive: alloc packet error")
+	if pkt = media.NewPacket(); pkt == nil {
+		err = averror.New("Encoder Receive: alloc packet", averror.CodeNoMem)
 		return
 	}
-	pPkt.AvInitPacket()
 
 	/*
 	 * @return
@@ -127,50 +219,123 @@ func (e *Encoder) Receive() (pPkt *libavcodec.AvPacket, err error) {
 	 *      AVERROR(EINVAL):   codec not opened, or it is an encoder
 	 *      other negative values: legitimate decoding errors
 	 */
-	ret := e.pEncCtx.AvcodecReceivePacket(pPkt)
-	if ret == libavutil.AvErrorEAGAIN || ret == libavutil.AvErrorEOF {
-		err = io.EOF
-		goto end
-	}
-	if ret < 0 {
-		err = fmt.Errorf("Encoder Receive: error(%v)", libavutil.ErrorFromCode(ret))
+	if ret := e.pEncCtx.AvcodecReceivePacket(pkt.AvPacket()); ret < 0 {
+		err = averror.New("Encoder Receive", ret)
 		goto end
 	}
-	pPkt.SetStreamIndex(e.streamIdx)
+	pkt.SetStreamIndex(e.streamIdx)
+	pkt.SetTimeBase(e.pEncCtx.TimeBase())
 	return
 
 end:
-	pPkt.AvPacketUnref()
-	pPkt = nil
+	pkt.Free()
+	pkt = nil
 	return
 }
 
-// Encode Encode frame to packet
+// Encode Encode frame to packet, encoded packets are passed to PacketHandler.
+// Audio frames are re-chunked to the frame size of the encoder if it requires, pts of
+// them are then generated from the number of samples. A nil frame is same as Flush
 func (e *Encoder) Encode(pFrame *libavutil.AvFrame) (err error) {
-	if err = e.Send(pFrame); err != nil {
-		if err == io.EOF {
-			err = nil
-		} else {
-			logger.Errorf("Encoder Encode: Send error(%v)", err)
+	if pFrame == nil {
+		return e.Flush()
+	}
+	if e.fifo == nil {
+		return e.encode(pFrame)
+	}
+	if err = e.fifo.write(pFrame); err != nil {
+		return
+	}
+	return e.encodeFifo(false)
+}
+
+// encodeFifo encode frames of frame size buffered in fifo, and the remaining samples if flush
+func (e *Encoder) encodeFifo(flush bool) (err error) {
+	var frame *media.Frame
+	for {
+		if frame, err = e.fifo.read(flush); err != nil || frame == nil {
+			return
+		}
+		err = e.encode(frame.AvFrame())
+		frame.Free()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// encode send frame, if the encoder does not accept it, buffered packets are
+// received first and the frame is sent again
+func (e *Encoder) encode(pFrame *libavutil.AvFrame) (err error) {
+	for {
+		if err = e.Send(pFrame); err == nil {
+			break
 		}
+		if !errors.Is(err, averror.ErrAgain) {
+			e.log().Error("Encoder Encode: Send", logutil.F("stream", e.streamIdx), logutil.F("error", err))
+			return
+		}
+		var n int
+		if n, err = e.receiveAll(); err != nil {
+			return
+		}
+		if n == 0 {
+			err = fmt.Errorf("Encoder Encode: send returns EAGAIN but no packet is available")
+			return
+		}
+	}
+	_, err = e.receiveAll()
+	return
+}
+
+// Flush encode the last partial audio frame, padded with silence unless the encoder accepts
+// a small last frame, and drain all buffered packets through PacketHandler.
+// No frame can be sent after unless Reset is supported by the encoder
+func (e *Encoder) Flush() (err error) {
+	if e.fifo != nil {
+		if err = e.encodeFifo(true); err != nil {
+			return
+		}
+	}
+	// a nil frame enters draining mode, sending it again returns AVERROR_EOF
+	if err = e.Send(nil); err != nil && !errors.Is(err, averror.ErrEOF) {
 		return
 	}
+	_, err = e.receiveAll()
+	return
+}
+
+// Reset discard buffered samples and packets and reset the encoder, e.g. after seeking,
+// only encoders with AV_CODEC_CAP_ENCODER_FLUSH support it
+func (e *Encoder) Reset() {
+	if e.fifo != nil {
+		e.fifo.reset()
+	}
+	if e.pEncCtx != nil {
+		e.pEncCtx.AvcodecFlushBuffers()
+	}
+}
 
-	var pPkt *libavcodec.AvPacket
+// receiveAll pass packets to PacketHandler until more input is needed or
+// the encoder is fully flushed, return the number of packets
+func (e *Encoder) receiveAll() (n int, err error) {
+	var pkt *media.Packet
 	for {
-		if pPkt, err = e.Receive(); err != nil {
-			if err == io.EOF {
+		if pkt, err = e.Receive(); err != nil {
+			if errors.Is(err, averror.ErrAgain) || errors.Is(err, averror.ErrEOF) {
 				err = nil
 			} else {
-				logger.Errorf("Encoder Encode: Receive error(%v)", err)
+				e.log().Error("Encoder Encode: Receive", logutil.F("stream", e.streamIdx), logutil.F("error", err))
 			}
-			break
+			return
 		}
+		n++
 		if e.PacketHandler != nil {
-			if err = e.PacketHandler(pPkt); err != nil {
-				return
-			}
+			err = e.PacketHandler(pkt)
+		}
+		pkt.Free()
+		if err != nil {
+			return
 		}
 	}
-	return
 }
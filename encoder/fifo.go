@@ -0,0 +1,155 @@
+package encoder
+
+//#cgo pkg-config: libavcodec libavutil
+//#include <libavcodec/avcodec.h>
+//#include <libavutil/audio_fifo.h>
+//#include <libavutil/mathematics.h>
+//#include <libavutil/samplefmt.h>
+//
+//static int fifo_write_frame(AVAudioFifo *fifo, AVFrame *frame)
+//{
+//    return av_audio_fifo_write(fifo, (void **)frame->extended_data, frame->nb_samples);
+//}
+//
+//static int fifo_read_frame(AVAudioFifo *fifo, AVFrame *frame, int nb_samples)
+//{
+//    int ret = av_audio_fifo_read(fifo, (void **)frame->extended_data, nb_samples);
+//    if (ret < 0 || ret >= frame->nb_samples)
+//        return ret;
+//    // pad the last partial frame with silence
+//    av_samples_set_silence(frame->extended_data, ret, frame->nb_samples - ret,
+//                           frame->channels, frame->format);
+//    return ret;
+//}
+import "C"
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/xueqing/ffmpeg-demo/averror"
+	"github.com/xueqing/ffmpeg-demo/media"
+	"github.com/xueqing/ffmpeg-demo/util"
+	"github.com/xueqing/goav/libavcodec"
+	"github.com/xueqing/goav/libavutil"
+)
+
+// audioFifo re-chunk audio frames to the frame size of the encoder,
+// pts of output frames are generated from the number of samples
+type audioFifo struct {
+	pFifo      *C.AVAudioFifo
+	frameSize  int
+	sampleFmt  libavcodec.AvSampleFormat
+	sampleRate int
+	layout     uint64
+	channels   int
+	timeBase   libavcodec.AvRational
+	// smallLast send the last partial frame as is instead of padding it
+	smallLast bool
+	startPts  int64
+	samples   int64
+}
+
+// newAudioFifo return nil if the opened encoder accepts frames of any size
+func newAudioFifo(pEnc *libavcodec.AvCodec, pEncCtx *libavcodec.AvCodecContext) (f *audioFifo, err error) {
+	ctx := (*C.AVCodecContext)(unsafe.Pointer(pEncCtx))
+	caps := (*C.AVCodec)(unsafe.Pointer(pEnc)).capabilities
+	if ctx.codec_type != C.AVMEDIA_TYPE_AUDIO || ctx.frame_size <= 0 ||
+		caps&C.AV_CODEC_CAP_VARIABLE_FRAME_SIZE != 0 {
+		return
+	}
+	f = &audioFifo{
+		frameSize:  int(ctx.frame_size),
+		sampleFmt:  pEncCtx.SampleFmt(),
+		sampleRate: pEncCtx.SampleRate(),
+		layout:     pEncCtx.ChannelLayout(),
+		channels:   pEncCtx.Channels(),
+		timeBase:   pEncCtx.TimeBase(),
+		smallLast:  caps&C.AV_CODEC_CAP_SMALL_LAST_FRAME != 0,
+		startPts:   util.AvNoPtsValue,
+	}
+	if f.pFifo = C.av_audio_fifo_alloc(ctx.sample_fmt, ctx.channels, ctx.frame_size); f.pFifo == nil {
+		err = averror.New("Encoder Open: alloc audio fifo", averror.CodeNoMem)
+		f = nil
+	}
+	return
+}
+
+func (f *audioFifo) free() {
+	if f.pFifo != nil {
+		C.av_audio_fifo_free(f.pFifo)
+		f.pFifo = nil
+	}
+}
+
+// reset discard buffered samples, pts of the next frame written start a new timeline
+func (f *audioFifo) reset() {
+	if f.pFifo != nil {
+		C.av_audio_fifo_reset(f.pFifo)
+	}
+	f.startPts = util.AvNoPtsValue
+	f.samples = 0
+}
+
+// size return the number of buffered samples
+func (f *audioFifo) size() int {
+	return int(C.av_audio_fifo_size(f.pFifo))
+}
+
+// write buffer samples of pFrame, the first frame with pts set the start pts
+func (f *audioFifo) write(pFrame *libavutil.AvFrame) (err error) {
+	frame := (*C.AVFrame)(unsafe.Pointer(pFrame))
+	if libavcodec.AvSampleFormat(frame.format) != f.sampleFmt || int(frame.channels) != f.channels {
+		err = fmt.Errorf("Encoder Encode: frame format(%v) channels(%v) mismatch encoder format(%v) channels(%v)",
+			frame.format, frame.channels, f.sampleFmt, f.channels)
+		return
+	}
+	if f.startPts == util.AvNoPtsValue && int64(frame.pts) != util.AvNoPtsValue {
+		// samples already buffered are before this frame
+		f.startPts = int64(frame.pts) - int64(C.av_rescale_q(C.int64_t(f.size()),
+			C.AVRational{num: 1, den: C.int(f.sampleRate)}, rational(f.timeBase)))
+	}
+	if ret := int(C.fifo_write_frame(f.pFifo, frame)); ret < int(frame.nb_samples) {
+		err = averror.New("Encoder Encode: write audio fifo", ret)
+		return
+	}
+	return
+}
+
+// read return a frame of frame size, or nil if not enough samples are buffered.
+// If flush is set the remaining samples are returned, padded with silence if needed
+func (f *audioFifo) read(flush bool) (frame *media.Frame, err error) {
+	n := f.size()
+	if n == 0 || (n < f.frameSize && !flush) {
+		return
+	}
+	if n > f.frameSize {
+		n = f.frameSize
+	}
+	nbSamples := f.frameSize
+	if n < f.frameSize && f.smallLast {
+		nbSamples = n
+	}
+	if frame, err = media.NewAudioFrame(nbSamples, f.sampleFmt, f.sampleRate, f.layout); err != nil {
+		return
+	}
+	if ret := int(C.fifo_read_frame(f.pFifo, (*C.AVFrame)(unsafe.Pointer(frame.AvFrame())), C.int(n))); ret < n {
+		frame.Free()
+		frame = nil
+		err = averror.New("Encoder Encode: read audio fifo", ret)
+		return
+	}
+
+	startPts := f.startPts
+	if startPts == util.AvNoPtsValue {
+		startPts = 0
+	}
+	frame.SetPts(startPts + int64(C.av_rescale_q(C.int64_t(f.samples),
+		C.AVRational{num: 1, den: C.int(f.sampleRate)}, rational(f.timeBase))))
+	frame.SetTimeBase(f.timeBase)
+	f.samples += int64(n)
+	return
+}
+
+func rational(r libavcodec.AvRational) C.AVRational {
+	return C.AVRational{num: C.int(r.Num()), den: C.int(r.Den())}
+}
@@ -0,0 +1,125 @@
+package encoder
+
+//#cgo pkg-config: libavcodec libavutil
+//#include <libavcodec/avcodec.h>
+//#include <libavutil/channel_layout.h>
+import "C"
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/xueqing/goav/libavcodec"
+	"github.com/xueqing/goav/libavutil"
+)
+
+// Params describe the frames sent to the encoder and the stream it produces
+type Params struct {
+	MediaType libavutil.AvMediaType
+	// CodecID select the encoder when EncoderConfig.CodecName is empty
+	CodecID libavcodec.AvCodecID
+	// StreamIndex is set on encoded packets
+	StreamIndex int
+	// TimeBase of frames and packets, 1/SampleRate is used for audio if not set
+	TimeBase libavcodec.AvRational
+
+	// video
+	Width             int
+	Height            int
+	PixelFormat       libavcodec.AvPixelFormat
+	SampleAspectRatio libavcodec.AvRational
+	FrameRate         libavcodec.AvRational
+
+	// audio
+	SampleRate    int
+	SampleFormat  libavcodec.AvSampleFormat
+	ChannelLayout uint64
+
+	// GlobalHeader place global headers in extradata instead of every keyframe,
+	// set it when the muxer requires it, see muxer.Muxer.GlobalHeader
+	GlobalHeader bool
+}
+
+// ParamsFromCodecContext return Params producing frames of the same properties
+// (picture size, sample rate etc.) as the decoder context pDecCtx
+func ParamsFromCodecContext(pDecCtx *libavcodec.AvCodecContext, streamIdx int) Params {
+	p := Params{
+		MediaType:   libavutil.AvMediaType(pDecCtx.CodecType()),
+		CodecID:     libavcodec.AvCodecID(pDecCtx.CodecID()),
+		StreamIndex: streamIdx,
+	}
+	if p.MediaType == libavutil.AvmediaTypeVideo {
+		p.Width = pDecCtx.Width()
+		p.Height = pDecCtx.Height()
+		p.PixelFormat = pDecCtx.PixFmt()
+		p.SampleAspectRatio = pDecCtx.SampleAspectRatio()
+		p.FrameRate = pDecCtx.Framerate()
+		p.TimeBase = videoTimeBase(pDecCtx, p.FrameRate)
+	} else {
+		p.SampleRate = pDecCtx.SampleRate()
+		p.SampleFormat = pDecCtx.SampleFmt()
+		p.ChannelLayout = pDecCtx.ChannelLayout()
+		if p.ChannelLayout == 0 {
+			p.ChannelLayout = uint64(C.av_get_default_channel_layout(C.int(pDecCtx.Channels())))
+		}
+	}
+	return p
+}
+
+// videoTimeBase return 1/frameRate, video time_base can be set to whatever is handy and
+// supported by encoder. If the frame rate is unknown (0/1 for variable frame rate) use
+// time base of packets sent to decoder, or time base of decoder
+func videoTimeBase(pDecCtx *libavcodec.AvCodecContext, frameRate libavcodec.AvRational) libavcodec.AvRational {
+	if frameRate.Num() > 0 && frameRate.Den() > 0 {
+		return libavcodec.AvInvQ(frameRate)
+	}
+	pktTb := (*C.AVCodecContext)(unsafe.Pointer(pDecCtx)).pkt_timebase
+	if pktTb.num > 0 && pktTb.den > 0 {
+		return libavcodec.NewAvRational(int(pktTb.num), int(pktTb.den))
+	}
+	return pDecCtx.TimeBase()
+}
+
+// apply set p on codec context before opening
+func (p *Params) apply(pEncCtx *libavcodec.AvCodecContext) error {
+	ctx := (*C.AVCodecContext)(unsafe.Pointer(pEncCtx))
+	switch p.MediaType {
+	case libavutil.AvmediaTypeVideo:
+		if p.Width <= 0 || p.Height <= 0 {
+			return fmt.Errorf("invalid video size(%vx%v)", p.Width, p.Height)
+		}
+		pEncCtx.SetWidth(p.Width)
+		pEncCtx.SetHeight(p.Height)
+		pEncCtx.SetPixelFormat(p.PixelFormat)
+		pEncCtx.SetSampleAspectRatio(p.SampleAspectRatio)
+		if p.FrameRate.Num() > 0 && p.FrameRate.Den() > 0 {
+			pEncCtx.SetFramerate(p.FrameRate)
+		}
+		if p.TimeBase.Num() <= 0 || p.TimeBase.Den() <= 0 {
+			return fmt.Errorf("invalid video time base(%v/%v)", p.TimeBase.Num(), p.TimeBase.Den())
+		}
+		pEncCtx.SetTimebase(p.TimeBase)
+	case libavutil.AvmediaTypeAudio:
+		if p.SampleRate <= 0 {
+			return fmt.Errorf("invalid sample rate(%v)", p.SampleRate)
+		}
+		channels := int(C.av_get_channel_layout_nb_channels(C.uint64_t(p.ChannelLayout)))
+		if channels <= 0 {
+			return fmt.Errorf("invalid channel layout(%#x)", p.ChannelLayout)
+		}
+		pEncCtx.SetSampleRate(p.SampleRate)
+		pEncCtx.SetSampleFmt(p.SampleFormat)
+		pEncCtx.SetChannelLayout(p.ChannelLayout)
+		pEncCtx.SetChannels(channels)
+		tb := p.TimeBase
+		if tb.Num() <= 0 || tb.Den() <= 0 {
+			tb = libavcodec.NewAvRational(1, p.SampleRate)
+		}
+		pEncCtx.SetTimebase(tb)
+	default:
+		return fmt.Errorf("unsupported media type(%v)", p.MediaType)
+	}
+	if p.GlobalHeader {
+		ctx.flags |= C.AV_CODEC_FLAG_GLOBAL_HEADER
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/google/logger"
+
+	"github.com/xueqing/ffmpeg-demo/demuxer"
+	"github.com/xueqing/ffmpeg-demo/logutil"
+	"github.com/xueqing/ffmpeg-demo/muxer"
+)
+
+// remux input into a init segment and fragments of fragmented MP4
+func main() {
+	var (
+		verbose = flag.Bool("verbose", true, "print info level logs to stdout")
+		logPath = flag.String("log", "fmp4.log", "file path to save log")
+
+		iURL     = flag.String("iurl", "/home/kiki/github/ffmpeg-demo/resource/movie.flv", "input url")
+		iFmt     = flag.String("ifmt", "flv", "input format")
+		outDir   = flag.String("outdir", ".", "directory to save init.mp4 and fragments")
+		duration = flag.Duration("duration", 2*time.Second, "minimum fragment duration")
+		cmaf     = flag.Bool("cmaf", false, "write CMAF fragments")
+	)
+	flag.Parse()
+	logutil.Init(*verbose, false, *logPath)
+	defer logutil.Close()
+
+	demux := demuxer.New()
+	if err := demux.Open(*iURL, *iFmt); err != nil {
+		logger.Errorf("demuxer Open error(%v)", err)
+		return
+	}
+	defer demux.Close()
+
+	mux := muxer.New()
+	err := mux.OpenFragmented(muxer.FragmentConfig{
+		Mode:     muxer.FragmentKeyframe,
+		Duration: *duration,
+		CMAF:     *cmaf,
+		InitHandler: func(data []byte) error {
+			return ioutil.WriteFile(filepath.Join(*outDir, "init.mp4"), data, 0644)
+		},
+		FragmentHandler: func(frag muxer.Fragment, data []byte) error {
+			logger.Infof("fragment(%v) decode time(%v) duration(%v) keyframe(%v) size(%v)",
+				frag.Sequence, frag.DecodeTime, frag.Duration, frag.Keyframe, len(data))
+			return ioutil.WriteFile(filepath.Join(*outDir, fmt.Sprintf("fragment-%d.m4s", frag.Sequence)), data, 0644)
+		},
+	})
+	if err != nil {
+		logger.Errorf("muxer OpenFragmented error(%v)", err)
+		return
+	}
+	defer mux.Close()
+
+	iStreams, _ := demux.Streams()
+	for _, st := range iStreams {
+		if _, err := mux.AddStreamCopy(st); err != nil {
+			logger.Errorf("muxer AddStreamCopy error(%v)", err)
+			return
+		}
+	}
+	if err := mux.WriteHeader(nil); err != nil {
+		logger.Errorf("muxer WriteHeader error(%v)", err)
+		return
+	}
+
+	for {
+		pkt, err := demux.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			logger.Errorf("demuxer Read error(%v)", err)
+			return
+		}
+		err = mux.Write(pkt)
+		pkt.Free()
+		if err != nil {
+			logger.Errorf("muxer Write error(%v)", err)
+			return
+		}
+	}
+	if ret := mux.WriteTrailer(); ret < 0 {
+		logger.Errorf("muxer WriteTrailer error(%v)", ret)
+	}
+}
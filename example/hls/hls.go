@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/google/logger"
+
+	"github.com/xueqing/ffmpeg-demo/demuxer"
+	"github.com/xueqing/ffmpeg-demo/hls"
+	"github.com/xueqing/ffmpeg-demo/logutil"
+)
+
+// remux input into HLS segments, a media playlist and a master playlist
+func main() {
+	var (
+		verbose = flag.Bool("verbose", true, "print info level logs to stdout")
+		logPath = flag.String("log", "hls.log", "file path to save log")
+
+		iURL     = flag.String("iurl", "/home/kiki/github/ffmpeg-demo/resource/movie.flv", "input url")
+		iFmt     = flag.String("ifmt", "flv", "input format")
+		outDir   = flag.String("outdir", ".", "directory to save playlists and segments")
+		duration = flag.Duration("duration", 6*time.Second, "target segment duration")
+		fmp4     = flag.Bool("fmp4", false, "write fragmented MP4 segments instead of MPEG-TS")
+		live     = flag.Bool("live", false, "write a sliding window playlist and delete old segments")
+		listSize = flag.Int("listsize", 5, "number of segments in the live playlist")
+	)
+	flag.Parse()
+	logutil.Init(*verbose, false, *logPath)
+	defer logutil.Close()
+
+	demux := demuxer.New()
+	if err := demux.Open(*iURL, *iFmt); err != nil {
+		logger.Errorf("demuxer Open error(%v)", err)
+		return
+	}
+	defer demux.Close()
+
+	cfg := hls.Config{
+		Dir:            *outDir,
+		TargetDuration: *duration,
+		ListSize:       *listSize,
+		SegmentHandler: func(seg hls.Segment) error {
+			logger.Infof("segment(%v) %v start(%v) duration(%v) size(%v)",
+				seg.Sequence, seg.Name, seg.Start, seg.Duration, seg.Size)
+			return nil
+		},
+	}
+	if *fmp4 {
+		cfg.Format = hls.FormatFMP4
+	}
+	if *live {
+		cfg.Type = hls.Live
+		cfg.DeleteSegments = true
+	}
+	w, err := hls.New(cfg)
+	if err != nil {
+		logger.Errorf("hls New error(%v)", err)
+		return
+	}
+	defer w.Close()
+
+	iStreams, _ := demux.Streams()
+	for _, st := range iStreams {
+		if _, err := w.AddStreamCopy(st); err != nil {
+			logger.Errorf("hls AddStreamCopy error(%v)", err)
+			return
+		}
+	}
+	if err := w.WriteHeader(); err != nil {
+		logger.Errorf("hls WriteHeader error(%v)", err)
+		return
+	}
+
+	for {
+		pkt, err := demux.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			logger.Errorf("demuxer Read error(%v)", err)
+			return
+		}
+		err = w.Write(pkt)
+		pkt.Free()
+		if err != nil {
+			logger.Errorf("hls Write error(%v)", err)
+			return
+		}
+	}
+	if err := w.WriteTrailer(); err != nil {
+		logger.Errorf("hls WriteTrailer error(%v)", err)
+		return
+	}
+
+	peak, average := w.Bandwidth()
+	err = hls.WriteMasterPlaylist(filepath.Join(*outDir, "master.m3u8"), nil, []hls.Variant{{
+		URI:              "index.m3u8",
+		Bandwidth:        peak,
+		AverageBandwidth: average,
+	}})
+	if err != nil {
+		logger.Errorf("hls WriteMasterPlaylist error(%v)", err)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"github.com/google/logger"
+
+	"github.com/xueqing/ffmpeg-demo/demuxer"
+	"github.com/xueqing/ffmpeg-demo/logutil"
+)
+
+// print media info as json, like ffprobe -show_format -show_streams -of json
+func main() {
+	var (
+		verbose = flag.Bool("verbose", false, "print info level logs to stdout")
+		logPath = flag.String("log", "probe.log", "file path to save log")
+
+		iURL = flag.String("iurl", "/home/kiki/github/ffmpeg-demo/resource/movie.flv", "input url")
+	)
+	flag.Parse()
+	logutil.Init(*verbose, false, *logPath)
+	defer logutil.Close()
+
+	info, err := demuxer.Probe(*iURL)
+	if err != nil {
+		logger.Errorf("Probe error(%v)", err)
+		return
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "    ")
+	if err = enc.Encode(info); err != nil {
+		logger.Errorf("json Encode error(%v)", err)
+	}
+}
@@ -0,0 +1,32 @@
+#include <libavutil/error.h>
+#include <libavutil/mem.h>
+#include <libavutil/opt.h>
+#include "filter.h"
+
+int filter_set_int_list(AVFilterContext *ctx, const char *key, const int *list, int n)
+{
+    return av_opt_set_bin(ctx, key, (const uint8_t *)list, n * sizeof(*list), AV_OPT_SEARCH_CHILDREN);
+}
+
+int filter_set_int64_list(AVFilterContext *ctx, const char *key, const int64_t *list, int n)
+{
+    return av_opt_set_bin(ctx, key, (const uint8_t *)list, n * sizeof(*list), AV_OPT_SEARCH_CHILDREN);
+}
+
+int filter_inout_prepend(AVFilterInOut **list, const char *name, AVFilterContext *ctx)
+{
+    AVFilterInOut *io = avfilter_inout_alloc();
+
+    if (!io)
+        return AVERROR(ENOMEM);
+    io->name = av_strdup(name);
+    if (!io->name) {
+        avfilter_inout_free(&io);
+        return AVERROR(ENOMEM);
+    }
+    io->filter_ctx = ctx;
+    io->pad_idx = 0;
+    io->next = *list;
+    *list = io;
+    return 0;
+}
@@ -0,0 +1,305 @@
+// Package filter process frames with libavfilter graphs described by FFmpeg filter strings
+package filter
+
+//#cgo pkg-config: libavfilter libavutil
+//#include <stdlib.h>
+//#include <libavfilter/avfilter.h>
+//#include <libavfilter/buffersrc.h>
+//#include <libavfilter/buffersink.h>
+//#include <libavutil/mem.h>
+//#include "filter.h"
+import "C"
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"github.com/xueqing/ffmpeg-demo/averror"
+	"github.com/xueqing/ffmpeg-demo/media"
+	"github.com/xueqing/goav/libavcodec"
+	"github.com/xueqing/goav/libavutil"
+)
+
+// Graph is a configured filter graph with buffer sources and sinks, not safe for concurrent use
+type Graph struct {
+	// FrameHandler receive frames pulled from output of index output,
+	// frame is freed after handler returns, call frame.Clone() to keep it
+	FrameHandler func(output int, frame *media.Frame) (err error)
+
+	pGraph  *C.AVFilterGraph
+	srcs    []*C.AVFilterContext
+	sinks   []*C.AVFilterContext
+	inputs  []Input
+	outputs []Output
+}
+
+// New build a graph from desc, e.g. "scale=1280:-2,fps=30,format=yuv420p" or "loudnorm".
+// Inputs and outputs are linked to the labels named by Input.Name and Output.Name, an
+// unlabeled graph with one input and one output is linked to them in order, e.g.
+// "[in0][in1]overlay=10:10[out]" requires inputs named in0 and in1 and a output named out
+func New(desc string, inputs []Input, outputs []Output) (g *Graph, err error) {
+	if len(inputs) == 0 || len(outputs) == 0 {
+		err = fmt.Errorf("filter New: graph requires at least one input and one output")
+		return
+	}
+	g = &Graph{
+		inputs:  inputs,
+		outputs: outputs,
+	}
+	if g.pGraph = C.avfilter_graph_alloc(); g.pGraph == nil {
+		err = averror.New("filter New: alloc graph", averror.CodeNoMem)
+		g = nil
+		return
+	}
+	if err = g.build(desc); err != nil {
+		g.Close()
+		g = nil
+	}
+	return
+}
+
+func (g *Graph) build(desc string) (err error) {
+	var pOutputs, pInputs *C.AVFilterInOut
+	defer func() {
+		C.avfilter_inout_free(&pOutputs)
+		C.avfilter_inout_free(&pInputs)
+	}()
+
+	// the lists are prepended, iterate backward to keep the order
+	for i := len(g.inputs) - 1; i >= 0; i-- {
+		var pSrc *C.AVFilterContext
+		if pSrc, err = g.inputs[i].create(g.pGraph, i); err != nil {
+			return
+		}
+		g.srcs = append([]*C.AVFilterContext{pSrc}, g.srcs...)
+		// outputs of the sources are open inputs of the parsed graph
+		if err = inoutPrepend(&pOutputs, g.inputs[i].label(i, len(g.inputs)), pSrc); err != nil {
+			return
+		}
+	}
+	for i := len(g.outputs) - 1; i >= 0; i-- {
+		var pSink *C.AVFilterContext
+		if pSink, err = g.outputs[i].create(g.pGraph, i); err != nil {
+			return
+		}
+		g.sinks = append([]*C.AVFilterContext{pSink}, g.sinks...)
+		if err = inoutPrepend(&pInputs, g.outputs[i].label(i, len(g.outputs)), pSink); err != nil {
+			return
+		}
+	}
+
+	cDesc := C.CString(desc)
+	defer C.free(unsafe.Pointer(cDesc))
+	if ret := int(C.avfilter_graph_parse_ptr(g.pGraph, cDesc, &pInputs, &pOutputs, nil)); ret < 0 {
+		err = averror.New(fmt.Sprintf("filter New: parse graph(%v)", desc), ret)
+		return
+	}
+	if ret := int(C.avfilter_graph_config(g.pGraph, nil)); ret < 0 {
+		err = averror.New(fmt.Sprintf("filter New: config graph(%v)", desc), ret)
+		return
+	}
+	return
+}
+
+func inoutPrepend(list **C.AVFilterInOut, name string, ctx *C.AVFilterContext) error {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	if ret := int(C.filter_inout_prepend(list, cName, ctx)); ret < 0 {
+		return averror.New("filter New: alloc inout", ret)
+	}
+	return nil
+}
+
+// Close free the graph and all its filters
+func (g *Graph) Close() {
+	if g.pGraph != nil {
+		C.avfilter_graph_free(&g.pGraph)
+	}
+	g.srcs = nil
+	g.sinks = nil
+}
+
+// NbInputs return number of inputs
+func (g *Graph) NbInputs() int {
+	return len(g.srcs)
+}
+
+// NbOutputs return number of outputs
+func (g *Graph) NbOutputs() int {
+	return len(g.sinks)
+}
+
+// OutputTimeBase return time base of frames pulled from output
+func (g *Graph) OutputTimeBase(output int) libavcodec.AvRational {
+	tb := C.av_buffersink_get_time_base(g.sinks[output])
+	return libavcodec.NewAvRational(int(tb.num), int(tb.den))
+}
+
+// OutputFormat return format of frames pulled from output in the form of Input,
+// e.g. to set up the encoder or to chain another graph
+func (g *Graph) OutputFormat(output int) Input {
+	pSink := g.sinks[output]
+	out := Input{
+		Name:      g.outputs[output].Name,
+		MediaType: g.outputs[output].MediaType,
+		TimeBase:  g.OutputTimeBase(output),
+	}
+	if out.MediaType == libavutil.AvmediaTypeVideo {
+		sar := C.av_buffersink_get_sample_aspect_ratio(pSink)
+		frameRate := C.av_buffersink_get_frame_rate(pSink)
+		out.Width = int(C.av_buffersink_get_w(pSink))
+		out.Height = int(C.av_buffersink_get_h(pSink))
+		out.PixelFormat = libavcodec.AvPixelFormat(C.av_buffersink_get_format(pSink))
+		out.SampleAspectRatio = libavcodec.NewAvRational(int(sar.num), int(sar.den))
+		out.FrameRate = libavcodec.NewAvRational(int(frameRate.num), int(frameRate.den))
+	} else {
+		out.SampleRate = int(C.av_buffersink_get_sample_rate(pSink))
+		out.SampleFormat = libavcodec.AvSampleFormat(C.av_buffersink_get_format(pSink))
+		out.ChannelLayout = uint64(C.av_buffersink_get_channel_layout(pSink))
+	}
+	return out
+}
+
+// Dump return a human readable description of the configured graph
+func (g *Graph) Dump() string {
+	cDump := C.avfilter_graph_dump(g.pGraph, nil)
+	defer C.av_free(unsafe.Pointer(cDump))
+	return C.GoString(cDump)
+}
+
+// Push add frame to input, a nil frame mark end of stream of input.
+// The frame is referenced and not freed
+func (g *Graph) Push(input int, frame *media.Frame) (err error) {
+	if input < 0 || input >= len(g.srcs) {
+		err = fmt.Errorf("Graph Push: invalid input(%v)", input)
+		return
+	}
+	var pFrame *C.AVFrame
+	if frame != nil {
+		pFrame = (*C.AVFrame)(unsafe.Pointer(frame.AvFrame()))
+	}
+	if ret := int(C.av_buffersrc_add_frame_flags(g.srcs[input], pFrame, C.AV_BUFFERSRC_FLAG_KEEP_REF)); ret < 0 {
+		err = averror.New("Graph Push", ret)
+		return
+	}
+	return
+}
+
+// Pull get a filtered frame from output, tagged with the output time base.
+// Return nil frame when more input is needed, averror.ErrEOF when the output is finished.
+// Must call frame.Free() after use
+func (g *Graph) Pull(output int) (frame *media.Frame, err error) {
+	if output < 0 || output >= len(g.sinks) {
+		err = fmt.Errorf("Graph Pull: invalid output(%v)", output)
+		return
+	}
+	if frame = media.NewFrame(); frame == nil {
+		err = averror.New("Graph Pull: alloc frame", averror.CodeNoMem)
+		return
+	}
+	ret := int(C.av_buffersink_get_frame(g.sinks[output], (*C.AVFrame)(unsafe.Pointer(frame.AvFrame()))))
+	if ret < 0 {
+		frame.Free()
+		frame = nil
+		if ret != libavutil.AvErrorEAGAIN {
+			err = averror.New("Graph Pull", ret)
+		}
+		return
+	}
+	frame.SetTimeBase(g.OutputTimeBase(output))
+	return
+}
+
+// Drain pull all available frames of every output through FrameHandler
+func (g *Graph) Drain() (err error) {
+	for i := range g.sinks {
+		if err = g.drain(i); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (g *Graph) drain(output int) (err error) {
+	var frame *media.Frame
+	for {
+		if frame, err = g.Pull(output); err != nil {
+			if errors.Is(err, averror.ErrEOF) {
+				err = nil
+			}
+			return
+		}
+		if frame == nil {
+			return
+		}
+		if g.FrameHandler != nil {
+			err = g.FrameHandler(output, frame)
+		}
+		frame.Free()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Handle push frame to the first input and drain outputs, it can be used as
+// FrameHandler of decoder.Decoder. The input frame is not freed
+func (g *Graph) Handle(frame *media.Frame) (err error) {
+	return g.InputHandler(0)(frame)
+}
+
+// InputHandler return a frame handler pushing to input and draining outputs,
+// e.g. as FrameHandler of the decoders of overlay or amix inputs
+func (g *Graph) InputHandler(input int) func(frame *media.Frame) error {
+	return func(frame *media.Frame) (err error) {
+		if err = g.Push(input, frame); err != nil {
+			return
+		}
+		return g.Drain()
+	}
+}
+
+// Flush mark end of stream on all inputs and drain the remaining frames at end of stream
+func (g *Graph) Flush() (err error) {
+	for i := range g.srcs {
+		if err = g.Push(i, nil); err != nil && !errors.Is(err, averror.ErrEOF) {
+			return
+		}
+	}
+	return g.Drain()
+}
+
+// ProcessCommand send cmd with arg to filters matching target ("all", a filter
+// instance name or a filter name), e.g. ("drawtext", "reinit", "text=foo").
+// Return the response of the first filter handling it
+func (g *Graph) ProcessCommand(target, cmd, arg string) (resp string, err error) {
+	cTarget, cCmd, cArg := C.CString(target), C.CString(cmd), C.CString(arg)
+	defer func() {
+		C.free(unsafe.Pointer(cTarget))
+		C.free(unsafe.Pointer(cCmd))
+		C.free(unsafe.Pointer(cArg))
+	}()
+	var buf [4096]C.char
+	if ret := int(C.avfilter_graph_send_command(g.pGraph, cTarget, cCmd, cArg,
+		&buf[0], C.int(len(buf)), C.AVFILTER_CMD_FLAG_ONE)); ret < 0 {
+		err = averror.New(fmt.Sprintf("Graph ProcessCommand: %v %v(%v)", target, cmd, arg), ret)
+		return
+	}
+	resp = C.GoString(&buf[0])
+	return
+}
+
+// QueueCommand queue cmd to be processed when a frame with timestamp ts (in seconds) is filtered
+func (g *Graph) QueueCommand(target, cmd, arg string, ts float64) (err error) {
+	cTarget, cCmd, cArg := C.CString(target), C.CString(cmd), C.CString(arg)
+	defer func() {
+		C.free(unsafe.Pointer(cTarget))
+		C.free(unsafe.Pointer(cCmd))
+		C.free(unsafe.Pointer(cArg))
+	}()
+	if ret := int(C.avfilter_graph_queue_command(g.pGraph, cTarget, cCmd, cArg, 0, C.double(ts))); ret < 0 {
+		err = averror.New(fmt.Sprintf("Graph QueueCommand: %v %v(%v)", target, cmd, arg), ret)
+		return
+	}
+	return
+}
@@ -0,0 +1,16 @@
+#ifndef FFMPEG_DEMO_FILTER_H
+#define FFMPEG_DEMO_FILTER_H
+
+#include <stdint.h>
+#include <libavfilter/avfilter.h>
+
+// set int list option key of ctx, e.g. pix_fmts of buffersink
+int filter_set_int_list(AVFilterContext *ctx, const char *key, const int *list, int n);
+
+// set int64 list option key of ctx, e.g. channel_layouts of abuffersink
+int filter_set_int64_list(AVFilterContext *ctx, const char *key, const int64_t *list, int n);
+
+// prepend a AVFilterInOut for pad 0 of ctx labeled name to *list
+int filter_inout_prepend(AVFilterInOut **list, const char *name, AVFilterContext *ctx);
+
+#endif
@@ -0,0 +1,225 @@
+package filter
+
+//#include <stdlib.h>
+//#include <libavfilter/avfilter.h>
+//#include <libavutil/channel_layout.h>
+//#include <libavutil/samplefmt.h>
+//#include "filter.h"
+import "C"
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/xueqing/ffmpeg-demo/averror"
+	"github.com/xueqing/goav/libavcodec"
+	"github.com/xueqing/goav/libavutil"
+)
+
+// Input describe frames pushed to a buffer source
+type Input struct {
+	// Name is the label of the input in the graph description, default "in",
+	// or "in0", "in1"... if the graph has several inputs
+	Name      string
+	MediaType libavutil.AvMediaType
+	// TimeBase of frame pts
+	TimeBase libavcodec.AvRational
+
+	// video
+	Width             int
+	Height            int
+	PixelFormat       libavcodec.AvPixelFormat
+	SampleAspectRatio libavcodec.AvRational
+	FrameRate         libavcodec.AvRational
+
+	// audio
+	SampleRate    int
+	SampleFormat  libavcodec.AvSampleFormat
+	ChannelLayout uint64
+}
+
+// InputFromCodecContext return Input receiving frames of decoder context pDecCtx with pts in tb,
+// e.g. decoder.Decoder.TimeBase()
+func InputFromCodecContext(name string, pDecCtx *libavcodec.AvCodecContext, tb libavcodec.AvRational) Input {
+	in := Input{
+		Name:      name,
+		MediaType: libavutil.AvMediaType(pDecCtx.CodecType()),
+		TimeBase:  tb,
+	}
+	if in.MediaType == libavutil.AvmediaTypeVideo {
+		in.Width = pDecCtx.Width()
+		in.Height = pDecCtx.Height()
+		in.PixelFormat = pDecCtx.PixFmt()
+		in.SampleAspectRatio = pDecCtx.SampleAspectRatio()
+		in.FrameRate = pDecCtx.Framerate()
+	} else {
+		in.SampleRate = pDecCtx.SampleRate()
+		in.SampleFormat = pDecCtx.SampleFmt()
+		in.ChannelLayout = pDecCtx.ChannelLayout()
+		if in.ChannelLayout == 0 {
+			in.ChannelLayout = uint64(C.av_get_default_channel_layout(C.int(pDecCtx.Channels())))
+		}
+		if in.TimeBase.Num() <= 0 || in.TimeBase.Den() <= 0 {
+			in.TimeBase = libavcodec.NewAvRational(1, in.SampleRate)
+		}
+	}
+	return in
+}
+
+func (in *Input) label(i, n int) string {
+	if in.Name != "" {
+		return in.Name
+	}
+	if n == 1 {
+		return "in"
+	}
+	return fmt.Sprintf("in%d", i)
+}
+
+// args return arguments of buffer or abuffer filter
+func (in *Input) args() (filterName, args string, err error) {
+	switch in.MediaType {
+	case libavutil.AvmediaTypeVideo:
+		filterName = "buffer"
+		sar := in.SampleAspectRatio
+		if sar.Num() <= 0 || sar.Den() <= 0 {
+			sar = libavcodec.NewAvRational(0, 1)
+		}
+		args = fmt.Sprintf("video_size=%dx%d:pix_fmt=%d:time_base=%d/%d:pixel_aspect=%d/%d",
+			in.Width, in.Height, int(in.PixelFormat), in.TimeBase.Num(), in.TimeBase.Den(), sar.Num(), sar.Den())
+		if in.FrameRate.Num() > 0 && in.FrameRate.Den() > 0 {
+			args += fmt.Sprintf(":frame_rate=%d/%d", in.FrameRate.Num(), in.FrameRate.Den())
+		}
+	case libavutil.AvmediaTypeAudio:
+		filterName = "abuffer"
+		name := C.av_get_sample_fmt_name(C.enum_AVSampleFormat(in.SampleFormat))
+		if name == nil {
+			err = fmt.Errorf("invalid sample format(%v)", in.SampleFormat)
+			return
+		}
+		args = fmt.Sprintf("time_base=%d/%d:sample_rate=%d:sample_fmt=%s:channel_layout=0x%x",
+			in.TimeBase.Num(), in.TimeBase.Den(), in.SampleRate, C.GoString(name), in.ChannelLayout)
+	default:
+		err = fmt.Errorf("unsupported media type(%v)", in.MediaType)
+	}
+	return
+}
+
+// create add the buffer source of in to pGraph
+func (in *Input) create(pGraph *C.AVFilterGraph, i int) (pSrc *C.AVFilterContext, err error) {
+	filterName, args, err := in.args()
+	if err != nil {
+		err = fmt.Errorf("filter New: input(%v) %w", i, err)
+		return
+	}
+	return createFilter(pGraph, filterName, fmt.Sprintf("src%d", i), args)
+}
+
+// Output constrain frames pulled from a buffer sink, empty lists accept any format
+type Output struct {
+	// Name is the label of the output in the graph description, default "out",
+	// or "out0", "out1"... if the graph has several outputs
+	Name      string
+	MediaType libavutil.AvMediaType
+
+	// video
+	PixelFormats []libavcodec.AvPixelFormat
+
+	// audio
+	SampleFormats  []libavcodec.AvSampleFormat
+	SampleRates    []int
+	ChannelLayouts []uint64
+}
+
+func (out *Output) label(i, n int) string {
+	if out.Name != "" {
+		return out.Name
+	}
+	if n == 1 {
+		return "out"
+	}
+	return fmt.Sprintf("out%d", i)
+}
+
+// create add the buffer sink of out to pGraph
+func (out *Output) create(pGraph *C.AVFilterGraph, i int) (pSink *C.AVFilterContext, err error) {
+	var filterName string
+	switch out.MediaType {
+	case libavutil.AvmediaTypeVideo:
+		filterName = "buffersink"
+	case libavutil.AvmediaTypeAudio:
+		filterName = "abuffersink"
+	default:
+		err = fmt.Errorf("filter New: output(%v) unsupported media type(%v)", i, out.MediaType)
+		return
+	}
+	if pSink, err = createFilter(pGraph, filterName, fmt.Sprintf("sink%d", i), ""); err != nil {
+		return
+	}
+
+	ints := func(key string, list []C.int) error {
+		if len(list) == 0 {
+			return nil
+		}
+		cKey := C.CString(key)
+		defer C.free(unsafe.Pointer(cKey))
+		if ret := int(C.filter_set_int_list(pSink, cKey, &list[0], C.int(len(list)))); ret < 0 {
+			return averror.New(fmt.Sprintf("filter New: output(%v) set %v", i, key), ret)
+		}
+		return nil
+	}
+	var pixFmts, sampleFmts, sampleRates []C.int
+	for _, f := range out.PixelFormats {
+		pixFmts = append(pixFmts, C.int(f))
+	}
+	for _, f := range out.SampleFormats {
+		sampleFmts = append(sampleFmts, C.int(f))
+	}
+	for _, r := range out.SampleRates {
+		sampleRates = append(sampleRates, C.int(r))
+	}
+	if err = ints("pix_fmts", pixFmts); err != nil {
+		return
+	}
+	if err = ints("sample_fmts", sampleFmts); err != nil {
+		return
+	}
+	if err = ints("sample_rates", sampleRates); err != nil {
+		return
+	}
+	if len(out.ChannelLayouts) > 0 {
+		layouts := make([]C.int64_t, 0, len(out.ChannelLayouts))
+		for _, l := range out.ChannelLayouts {
+			layouts = append(layouts, C.int64_t(l))
+		}
+		cKey := C.CString("channel_layouts")
+		defer C.free(unsafe.Pointer(cKey))
+		if ret := int(C.filter_set_int64_list(pSink, cKey, &layouts[0], C.int(len(layouts)))); ret < 0 {
+			err = averror.New(fmt.Sprintf("filter New: output(%v) set channel_layouts", i), ret)
+			return
+		}
+	}
+	return
+}
+
+// createFilter create and add filter instance name of filterName with args to pGraph
+func createFilter(pGraph *C.AVFilterGraph, filterName, name, args string) (pCtx *C.AVFilterContext, err error) {
+	cFilterName := C.CString(filterName)
+	defer C.free(unsafe.Pointer(cFilterName))
+	pFilter := C.avfilter_get_by_name(cFilterName)
+	if pFilter == nil {
+		err = averror.New(fmt.Sprintf("filter New: get filter(%v)", filterName), averror.CodeFilterNotFound)
+		return
+	}
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	var cArgs *C.char
+	if args != "" {
+		cArgs = C.CString(args)
+		defer C.free(unsafe.Pointer(cArgs))
+	}
+	if ret := int(C.avfilter_graph_create_filter(&pCtx, pFilter, cName, cArgs, nil, pGraph)); ret < 0 {
+		err = averror.New(fmt.Sprintf("filter New: create filter(%v) args(%v)", name, args), ret)
+		return
+	}
+	return
+}
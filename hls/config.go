@@ -0,0 +1,149 @@
+package hls
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xueqing/ffmpeg-demo/logutil"
+)
+
+// SegmentFormat is container of media segments
+type SegmentFormat int
+
+// Formats of segments
+const (
+	// FormatTS write MPEG-TS segments
+	FormatTS SegmentFormat = iota
+	// FormatFMP4 write fragmented MP4 segments with a init segment (EXT-X-MAP)
+	FormatFMP4
+)
+
+// PlaylistType decide how the media playlist is updated
+type PlaylistType int
+
+// Types of playlist
+const (
+	// VOD write the playlist of all segments once at end, with EXT-X-PLAYLIST-TYPE:VOD
+	VOD PlaylistType = iota
+	// Event update the playlist after each segment, segments are never removed
+	Event
+	// Live update the playlist after each segment, only the last Config.ListSize
+	// segments are kept (sliding window)
+	Live
+)
+
+// String return the value of EXT-X-PLAYLIST-TYPE, empty for Live
+func (t PlaylistType) String() string {
+	switch t {
+	case VOD:
+		return "VOD"
+	case Event:
+		return "EVENT"
+	}
+	return ""
+}
+
+// defaults of Config
+const (
+	defaultPlaylist        = "index.m3u8"
+	defaultInitName        = "init.mp4"
+	defaultTargetDuration  = 6 * time.Second
+	defaultListSize        = 5
+	defaultDeleteThreshold = 1
+)
+
+// Config configure a Writer
+type Config struct {
+	// Dir is the directory of the playlist and segments, it must exist
+	Dir string
+	// Playlist is file name of the media playlist, default "index.m3u8"
+	Playlist string
+	Format   SegmentFormat
+	Type     PlaylistType
+
+	// TargetDuration of segments, default 6s. Segments are cut at the first keyframe of
+	// the reference stream after it, so they may be longer if keyframes are sparse.
+	// EXT-X-TARGETDURATION of Event and Live is fixed to it rounded to seconds
+	TargetDuration time.Duration
+	// RefStream is index of the stream deciding segment boundaries,
+	// nil select the first video stream, or the first stream if there is no video
+	RefStream *int
+
+	// SegmentTemplate is a printf style template of segment file names formatted with
+	// the sequence number, default "segment%d.ts" or "segment%d.m4s", e.g. "live-%05d.ts"
+	SegmentTemplate string
+	// InitName is file name of the init segment of FormatFMP4, default "init.mp4"
+	InitName string
+	// StartSequence is the sequence number of the first segment
+	StartSequence int
+
+	// ListSize is number of segments in the playlist of Live, default 5
+	ListSize int
+	// DeleteSegments delete files of segments removed from the playlist of Live
+	DeleteSegments bool
+	// DeleteThreshold is number of removed segments kept on disk before deleting,
+	// since clients may still be downloading them, default 1
+	DeleteThreshold int
+
+	// Options are passed to the segment muxer when writing header, e.g. mpegts_flags
+	Options map[string]interface{}
+	// SegmentHandler is called after a segment is written and the playlist is updated
+	SegmentHandler func(seg Segment) error
+	// Logger is set on segment muxers, nil use logutil.Default()
+	Logger *logutil.Logger
+}
+
+// check validate cfg and fill defaults
+func (cfg *Config) check() error {
+	if cfg.Dir == "" {
+		cfg.Dir = "."
+	}
+	if cfg.Playlist == "" {
+		cfg.Playlist = defaultPlaylist
+	}
+	if cfg.Format != FormatTS && cfg.Format != FormatFMP4 {
+		return fmt.Errorf("hls New: invalid segment format(%v)", cfg.Format)
+	}
+	if cfg.Type < VOD || cfg.Type > Live {
+		return fmt.Errorf("hls New: invalid playlist type(%v)", cfg.Type)
+	}
+	if cfg.TargetDuration == 0 {
+		cfg.TargetDuration = defaultTargetDuration
+	}
+	if cfg.TargetDuration < 0 {
+		return fmt.Errorf("hls New: invalid target duration(%v)", cfg.TargetDuration)
+	}
+	if cfg.SegmentTemplate == "" {
+		cfg.SegmentTemplate = "segment%d.ts"
+		if cfg.Format == FormatFMP4 {
+			cfg.SegmentTemplate = "segment%d.m4s"
+		}
+	}
+	// template must have exactly one integer verb: a missing, extra or wrong verb is
+	// formatted as "%!", and names of different sequence numbers must differ
+	if name := fmt.Sprintf(cfg.SegmentTemplate, 0); strings.Contains(name, "%!") ||
+		name == fmt.Sprintf(cfg.SegmentTemplate, 1) {
+		return fmt.Errorf("hls New: segment template(%v) does not format sequence number", cfg.SegmentTemplate)
+	}
+	if cfg.InitName == "" {
+		cfg.InitName = defaultInitName
+	}
+	if cfg.ListSize <= 0 {
+		cfg.ListSize = defaultListSize
+	}
+	if cfg.DeleteThreshold <= 0 {
+		cfg.DeleteThreshold = defaultDeleteThreshold
+	}
+	return nil
+}
+
+// Segment describe a written media segment
+type Segment struct {
+	Sequence int
+	// Name is file name of the segment in Config.Dir
+	Name     string
+	Start    time.Duration
+	Duration time.Duration
+	Size     int64
+}
@@ -0,0 +1,363 @@
+// Package hls write HTTP Live Streaming output: MPEG-TS or fragmented MP4 segments cut at
+// keyframes, and media and master playlists on the local filesystem
+package hls
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/xueqing/ffmpeg-demo/averror"
+	"github.com/xueqing/ffmpeg-demo/encoder"
+	"github.com/xueqing/ffmpeg-demo/logutil"
+	"github.com/xueqing/ffmpeg-demo/media"
+	"github.com/xueqing/ffmpeg-demo/muxer"
+	"github.com/xueqing/goav/libavformat"
+	"github.com/xueqing/goav/libavutil"
+)
+
+// source is what a output stream is created from, a input stream to copy or a encoder
+type source struct {
+	pStream *libavformat.AvStream
+	enc     *encoder.Encoder
+}
+
+func (s *source) mediaType() libavutil.AvMediaType {
+	if s.enc != nil {
+		return s.enc.MediaType()
+	}
+	return libavutil.AvMediaType(s.pStream.CodecParameters().CodecType())
+}
+
+// Writer write packets into segments and playlists, not safe for concurrent use
+type Writer struct {
+	cfg     Config
+	sources []source
+	// mux is the fragmented MP4 muxer, or the muxer of the current MPEG-TS segment
+	mux *muxer.Muxer
+	ref int
+
+	// segments in the playlist, and removed from it but not deleted yet
+	segments []Segment
+	removed  []Segment
+	seq      int
+	// EXT-X-TARGETDURATION in seconds of Event and Live, it must not change once written
+	targetDuration int
+	// longest segment written, target duration of VOD is not less than it
+	maxDuration time.Duration
+	// total size and duration of all segments
+	totalSize     int64
+	totalDuration time.Duration
+	peakBandwidth int
+
+	// current MPEG-TS segment
+	started  bool
+	start    time.Duration
+	end      time.Duration
+	curName  string
+	finished bool
+}
+
+// New create a Writer, add streams then call WriteHeader
+func New(cfg Config) (w *Writer, err error) {
+	if err = cfg.check(); err != nil {
+		return
+	}
+	w = &Writer{
+		cfg:            cfg,
+		seq:            cfg.StartSequence,
+		targetDuration: roundSeconds(cfg.TargetDuration),
+	}
+	return
+}
+
+// AddStreamCopy add a stream of packets of pInStream copied without transcoding,
+// return index of the stream to set on packets
+func (w *Writer) AddStreamCopy(pInStream *libavformat.AvStream) (idx int, err error) {
+	return w.addSource(source{pStream: pInStream})
+}
+
+// AddStreamFromEncoder add a stream of packets of the opened encoder enc,
+// return index of the stream to set on packets
+func (w *Writer) AddStreamFromEncoder(enc *encoder.Encoder) (idx int, err error) {
+	return w.addSource(source{enc: enc})
+}
+
+func (w *Writer) addSource(src source) (idx int, err error) {
+	if w.mux != nil {
+		err = fmt.Errorf("hls Writer AddStream: header is written")
+		return
+	}
+	idx = len(w.sources)
+	w.sources = append(w.sources, src)
+	return
+}
+
+// WriteHeader open the first segment, or write the init segment of FormatFMP4
+func (w *Writer) WriteHeader() (err error) {
+	if w.mux != nil {
+		err = fmt.Errorf("hls Writer WriteHeader: header is written")
+		return
+	}
+	if len(w.sources) == 0 {
+		err = fmt.Errorf("hls Writer WriteHeader: no stream")
+		return
+	}
+	if w.cfg.RefStream != nil {
+		if w.ref = *w.cfg.RefStream; w.ref < 0 || w.ref >= len(w.sources) {
+			err = fmt.Errorf("hls Writer WriteHeader: invalid reference stream(%v)", w.ref)
+			return
+		}
+	} else {
+		for idx := range w.sources {
+			if w.sources[idx].mediaType() == libavutil.AvmediaTypeVideo {
+				w.ref = idx
+				break
+			}
+		}
+	}
+
+	if w.cfg.Format == FormatFMP4 {
+		return w.openFragmented()
+	}
+	return w.openSegment()
+}
+
+// openFragmented open the fragmented MP4 muxer, each fragment is a segment
+func (w *Writer) openFragmented() (err error) {
+	ref := w.ref
+	w.mux = muxer.New()
+	w.mux.Logger = w.cfg.Logger
+	if err = w.mux.OpenFragmented(muxer.FragmentConfig{
+		Mode:      muxer.FragmentKeyframe,
+		Duration:  w.cfg.TargetDuration,
+		RefStream: &ref,
+		InitHandler: func(data []byte) error {
+			return ioutil.WriteFile(w.path(w.cfg.InitName), data, 0644)
+		},
+		FragmentHandler: func(frag muxer.Fragment, data []byte) error {
+			name := w.segmentName()
+			if err := ioutil.WriteFile(w.path(name), data, 0644); err != nil {
+				return err
+			}
+			return w.addSegment(Segment{
+				Name:     name,
+				Start:    frag.DecodeTime,
+				Duration: frag.Duration,
+				Size:     int64(len(data)),
+			})
+		},
+	}); err != nil {
+		return
+	}
+	if err = w.addStreams(); err != nil {
+		return
+	}
+	return w.mux.WriteHeader(w.cfg.Options)
+}
+
+// openSegment open the muxer of the next MPEG-TS segment
+func (w *Writer) openSegment() (err error) {
+	w.curName = w.segmentName()
+	w.mux = muxer.New()
+	w.mux.Logger = w.cfg.Logger
+	if err = w.mux.Open(w.path(w.curName), "mpegts"); err != nil {
+		return
+	}
+	if err = w.addStreams(); err != nil {
+		return
+	}
+	return w.mux.WriteHeader(w.cfg.Options)
+}
+
+func (w *Writer) addStreams() (err error) {
+	for _, src := range w.sources {
+		if src.enc != nil {
+			_, err = w.mux.AddStreamFromEncoder(src.enc)
+		} else {
+			_, err = w.mux.AddStreamCopy(src.pStream)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// closeSegment finish the current MPEG-TS segment ending at end
+func (w *Writer) closeSegment() (err error) {
+	ret := w.mux.WriteTrailer()
+	w.mux.Close()
+	w.mux = nil
+	if ret < 0 {
+		err = averror.New(fmt.Sprintf("hls Writer: write trailer of segment(%v)", w.curName), ret)
+		return
+	}
+	info, err := os.Stat(w.path(w.curName))
+	if err != nil {
+		return
+	}
+	w.started = false
+	return w.addSegment(Segment{
+		Name:     w.curName,
+		Start:    w.start,
+		Duration: w.end - w.start,
+		Size:     info.Size(),
+	})
+}
+
+// Write write pkt tagged with its time base to the stream of pkt.StreamIndex().
+// A new segment starts at the first keyframe of the reference stream after target duration.
+// Packets must be interleaved by caller, pkt is unreferenced but still must be freed by caller
+func (w *Writer) Write(pkt *media.Packet) (err error) {
+	if w.mux == nil {
+		err = fmt.Errorf("hls Writer Write: header is not written")
+		return
+	}
+	if w.cfg.Format == FormatTS && pkt.StreamIndex() == w.ref {
+		if err = w.checkCut(pkt); err != nil {
+			return
+		}
+	}
+	return w.mux.Write(pkt)
+}
+
+// checkCut start a new MPEG-TS segment before pkt of the reference stream if needed
+func (w *Writer) checkCut(pkt *media.Packet) (err error) {
+	dts := pkt.Dts()
+	if dts == media.NoPts {
+		if dts = pkt.Pts(); dts == media.NoPts {
+			return
+		}
+	}
+	if w.started && pkt.IsKeyframe() && dts-w.start >= w.cfg.TargetDuration {
+		w.end = dts
+		if err = w.closeSegment(); err != nil {
+			return
+		}
+		if err = w.openSegment(); err != nil {
+			return
+		}
+	}
+	if !w.started {
+		w.started = true
+		w.start = dts
+	}
+	w.end = dts + pkt.Duration()
+	return
+}
+
+// WriteTrailer finish the last segment and write the final playlist with EXT-X-ENDLIST
+func (w *Writer) WriteTrailer() (err error) {
+	if w.mux == nil || w.finished {
+		err = fmt.Errorf("hls Writer WriteTrailer: header is not written or trailer is written")
+		return
+	}
+	w.finished = true
+	if w.cfg.Format == FormatTS {
+		if err = w.closeSegment(); err != nil {
+			return
+		}
+	} else if ret := w.mux.WriteTrailer(); ret < 0 {
+		err = averror.New("hls Writer WriteTrailer", ret)
+		return
+	}
+	return w.writePlaylist()
+}
+
+// Close free the muxer, files are kept
+func (w *Writer) Close() {
+	if w.mux != nil {
+		w.mux.Close()
+		w.mux = nil
+	}
+}
+
+// Segments return segments in the playlist
+func (w *Writer) Segments() []Segment {
+	return append([]Segment(nil), w.segments...)
+}
+
+// Bandwidth return peak and average bit rate of segments in bit/s,
+// e.g. for BANDWIDTH and AVERAGE-BANDWIDTH of the master playlist
+func (w *Writer) Bandwidth() (peak, average int) {
+	if w.totalDuration > 0 {
+		average = int(float64(w.totalSize*8) / w.totalDuration.Seconds())
+	}
+	return w.peakBandwidth, average
+}
+
+// addSegment append seg to the playlist, remove and delete old segments of Live
+func (w *Writer) addSegment(seg Segment) (err error) {
+	seg.Sequence = w.seq
+	w.seq++
+	w.segments = append(w.segments, seg)
+	if seg.Duration > w.maxDuration {
+		w.maxDuration = seg.Duration
+	}
+	w.totalSize += seg.Size
+	w.totalDuration += seg.Duration
+	if seg.Duration > 0 {
+		if bw := int(float64(seg.Size*8) / seg.Duration.Seconds()); bw > w.peakBandwidth {
+			w.peakBandwidth = bw
+		}
+	}
+	w.log().Debug("hls Writer: segment", logutil.F("sequence", seg.Sequence), logutil.F("name", seg.Name),
+		logutil.F("duration", seg.Duration), logutil.F("size", seg.Size))
+	if w.cfg.Type != VOD && roundSeconds(seg.Duration) > w.targetDuration {
+		// segments are cut at keyframes, the keyframe interval should divide target duration
+		w.log().Warn("hls Writer: segment longer than target duration", logutil.F("name", seg.Name),
+			logutil.F("duration", seg.Duration), logutil.F("target", w.cfg.TargetDuration))
+	}
+
+	if w.cfg.Type == Live && len(w.segments) > w.cfg.ListSize {
+		n := len(w.segments) - w.cfg.ListSize
+		w.removed = append(w.removed, w.segments[:n]...)
+		w.segments = append([]Segment(nil), w.segments[n:]...)
+		if err = w.deleteSegments(); err != nil {
+			return
+		}
+	}
+	if w.cfg.Type != VOD {
+		if err = w.writePlaylist(); err != nil {
+			return
+		}
+	}
+	if w.cfg.SegmentHandler != nil {
+		err = w.cfg.SegmentHandler(seg)
+	}
+	return
+}
+
+// deleteSegments delete files of removed segments exceeding DeleteThreshold
+func (w *Writer) deleteSegments() error {
+	if !w.cfg.DeleteSegments {
+		w.removed = nil
+		return nil
+	}
+	for len(w.removed) > w.cfg.DeleteThreshold {
+		if err := os.Remove(w.path(w.removed[0].Name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("hls Writer: delete segment(%v) error(%v)", w.removed[0].Name, err)
+		}
+		w.removed = w.removed[1:]
+	}
+	return nil
+}
+
+func (w *Writer) segmentName() string {
+	return fmt.Sprintf(w.cfg.SegmentTemplate, w.seq)
+}
+
+func (w *Writer) path(name string) string {
+	return filepath.Join(w.cfg.Dir, name)
+}
+
+// log return Logger of config, or logutil.Default() if it is not set
+func (w *Writer) log() *logutil.Logger {
+	if w.cfg.Logger != nil {
+		return w.cfg.Logger
+	}
+	return logutil.Default()
+}
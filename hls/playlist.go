@@ -0,0 +1,166 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// writePlaylist write the media playlist, with EXT-X-ENDLIST after WriteTrailer
+func (w *Writer) writePlaylist() error {
+	var b bytes.Buffer
+	version := 3
+	if w.cfg.Format == FormatFMP4 {
+		// EXT-X-MAP of media segments
+		version = 7
+	}
+	// target duration of playlists updated while writing is fixed, VOD is written once at
+	// end so it cover the longest segment
+	target := w.targetDuration
+	if w.cfg.Type == VOD {
+		if max := roundSeconds(w.maxDuration); max > target {
+			target = max
+		}
+	}
+	sequence := w.seq
+	if len(w.segments) > 0 {
+		sequence = w.segments[0].Sequence
+	}
+
+	b.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&b, "#EXT-X-VERSION:%d\n", version)
+	// EXTINF rounded to the nearest integer must not exceed target duration
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", target)
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", sequence)
+	if typ := w.cfg.Type.String(); typ != "" {
+		fmt.Fprintf(&b, "#EXT-X-PLAYLIST-TYPE:%s\n", typ)
+	}
+	if w.cfg.Format == FormatFMP4 {
+		fmt.Fprintf(&b, "#EXT-X-MAP:URI=%q\n", w.cfg.InitName)
+	}
+	for _, seg := range w.segments {
+		fmt.Fprintf(&b, "#EXTINF:%.6f,\n%s\n", seg.Duration.Seconds(), seg.Name)
+	}
+	if w.finished {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return writeFile(w.path(w.cfg.Playlist), b.Bytes())
+}
+
+// Media is a rendition of EXT-X-MEDIA in the master playlist
+type Media struct {
+	// Type is AUDIO, VIDEO, SUBTITLES or CLOSED-CAPTIONS
+	Type       string
+	GroupID    string
+	Name       string
+	Language   string
+	Default    bool
+	AutoSelect bool
+	// URI of the media playlist, relative to the master playlist
+	URI string
+}
+
+// Variant is a variant stream of EXT-X-STREAM-INF in the master playlist
+type Variant struct {
+	// URI of the media playlist, relative to the master playlist
+	URI string
+	// Bandwidth and AverageBandwidth in bit/s, see Writer.Bandwidth
+	Bandwidth        int
+	AverageBandwidth int
+	// Codecs e.g. "avc1.64001f,mp4a.40.2"
+	Codecs    string
+	Width     int
+	Height    int
+	FrameRate float64
+	// Audio and Subtitles are GROUP-ID of renditions
+	Audio     string
+	Subtitles string
+}
+
+// WriteMasterPlaylist write the master playlist of renditions and variants to path
+func WriteMasterPlaylist(path string, renditions []Media, variants []Variant) error {
+	if len(variants) == 0 {
+		return fmt.Errorf("hls WriteMasterPlaylist: no variant")
+	}
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:6\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for _, m := range renditions {
+		fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=%s,GROUP-ID=%q,NAME=%q", m.Type, m.GroupID, m.Name)
+		if m.Language != "" {
+			fmt.Fprintf(&b, ",LANGUAGE=%q", m.Language)
+		}
+		fmt.Fprintf(&b, ",DEFAULT=%s,AUTOSELECT=%s", yesNo(m.Default), yesNo(m.AutoSelect))
+		if m.URI != "" {
+			fmt.Fprintf(&b, ",URI=%q", m.URI)
+		}
+		b.WriteString("\n")
+	}
+	for _, v := range variants {
+		if v.Bandwidth <= 0 || v.URI == "" {
+			return fmt.Errorf("hls WriteMasterPlaylist: variant(%v) requires URI and bandwidth", v.URI)
+		}
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d", v.Bandwidth)
+		if v.AverageBandwidth > 0 {
+			fmt.Fprintf(&b, ",AVERAGE-BANDWIDTH=%d", v.AverageBandwidth)
+		}
+		if v.Codecs != "" {
+			fmt.Fprintf(&b, ",CODECS=%q", v.Codecs)
+		}
+		if v.Width > 0 && v.Height > 0 {
+			fmt.Fprintf(&b, ",RESOLUTION=%dx%d", v.Width, v.Height)
+		}
+		if v.FrameRate > 0 {
+			fmt.Fprintf(&b, ",FRAME-RATE=%.3f", v.FrameRate)
+		}
+		if v.Audio != "" {
+			fmt.Fprintf(&b, ",AUDIO=%q", v.Audio)
+		}
+		if v.Subtitles != "" {
+			fmt.Fprintf(&b, ",SUBTITLES=%q", v.Subtitles)
+		}
+		fmt.Fprintf(&b, "\n%s\n", v.URI)
+	}
+	return writeFile(path, b.Bytes())
+}
+
+// writeFile replace path with data by renaming a temporary file,
+// so clients never read a partially written playlist
+func writeFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// roundSeconds round d to the nearest integer seconds, at least 1
+func roundSeconds(d time.Duration) int {
+	s := int(math.Floor(d.Seconds() + 0.5))
+	if s < 1 {
+		s = 1
+	}
+	return s
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "YES"
+	}
+	return "NO"
+}
@@ -0,0 +1,305 @@
+package hls
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestWriter create a Writer in a temporary directory
+func newTestWriter(t *testing.T, cfg Config) *Writer {
+	t.Helper()
+	cfg.Dir = t.TempDir()
+	w, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New error(%v)", err)
+	}
+	return w
+}
+
+// addSegments write segment files and add them to the playlist as the muxer does
+func addSegments(t *testing.T, w *Writer, durations ...time.Duration) {
+	t.Helper()
+	for _, d := range durations {
+		name := w.segmentName()
+		if err := ioutil.WriteFile(w.path(name), []byte("segment"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.addSegment(Segment{Name: name, Duration: d, Size: 7}); err != nil {
+			t.Fatalf("addSegment(%v) error(%v)", name, err)
+		}
+	}
+}
+
+func readPlaylist(t *testing.T, w *Writer) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(w.path(w.cfg.Playlist))
+	if err != nil {
+		t.Fatalf("read playlist error(%v)", err)
+	}
+	return string(data)
+}
+
+func exists(w *Writer, name string) bool {
+	_, err := os.Stat(w.path(name))
+	return err == nil
+}
+
+func TestConfigCheck(t *testing.T) {
+	for _, tc := range []struct {
+		template string
+		ok       bool
+	}{
+		{"", true},
+		{"seg%d.ts", true},
+		{"live-%05d.ts", true},
+		{"seg.ts", false},
+		{"seg%s.ts", false},
+		{"seg%d-%d.ts", false},
+		{"seg%%d.ts", false},
+	} {
+		cfg := Config{SegmentTemplate: tc.template}
+		if err := cfg.check(); (err == nil) != tc.ok {
+			t.Errorf("template(%q) error(%v), want ok(%v)", tc.template, err, tc.ok)
+		}
+	}
+
+	cfg := Config{Format: FormatFMP4}
+	if err := cfg.check(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Playlist != defaultPlaylist || cfg.SegmentTemplate != "segment%d.m4s" ||
+		cfg.TargetDuration != defaultTargetDuration || cfg.ListSize != defaultListSize {
+		t.Errorf("defaults not filled: %+v", cfg)
+	}
+	if err := (&Config{TargetDuration: -time.Second}).check(); err == nil {
+		t.Error("negative target duration is accepted")
+	}
+}
+
+func TestRoundSeconds(t *testing.T) {
+	for _, tc := range []struct {
+		d    time.Duration
+		want int
+	}{
+		{0, 1},
+		{400 * time.Millisecond, 1},
+		{1400 * time.Millisecond, 1},
+		{1500 * time.Millisecond, 2},
+		{6 * time.Second, 6},
+		{6499 * time.Millisecond, 6},
+		{6500 * time.Millisecond, 7},
+	} {
+		if got := roundSeconds(tc.d); got != tc.want {
+			t.Errorf("roundSeconds(%v) = %v, want %v", tc.d, got, tc.want)
+		}
+	}
+}
+
+func TestVODPlaylist(t *testing.T) {
+	var handled []int
+	w := newTestWriter(t, Config{
+		Type:           VOD,
+		TargetDuration: 4 * time.Second,
+		SegmentHandler: func(seg Segment) error {
+			handled = append(handled, seg.Sequence)
+			return nil
+		},
+	})
+	addSegments(t, w, 4*time.Second, 6500*time.Millisecond, 1500*time.Millisecond)
+	if exists(w, w.cfg.Playlist) {
+		t.Fatal("VOD playlist is written before end")
+	}
+	if len(handled) != 3 || handled[0] != 0 || handled[2] != 2 {
+		t.Errorf("SegmentHandler got sequences %v", handled)
+	}
+
+	w.finished = true
+	if err := w.writePlaylist(); err != nil {
+		t.Fatal(err)
+	}
+	want := "#EXTM3U\n" +
+		"#EXT-X-VERSION:3\n" +
+		"#EXT-X-TARGETDURATION:7\n" +
+		"#EXT-X-MEDIA-SEQUENCE:0\n" +
+		"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+		"#EXTINF:4.000000,\nsegment0.ts\n" +
+		"#EXTINF:6.500000,\nsegment1.ts\n" +
+		"#EXTINF:1.500000,\nsegment2.ts\n" +
+		"#EXT-X-ENDLIST\n"
+	if got := readPlaylist(t, w); got != want {
+		t.Errorf("playlist:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEventPlaylist(t *testing.T) {
+	w := newTestWriter(t, Config{Type: Event, StartSequence: 3, TargetDuration: 2 * time.Second})
+	addSegments(t, w, 2*time.Second)
+	got := readPlaylist(t, w)
+	if !strings.Contains(got, "#EXT-X-PLAYLIST-TYPE:EVENT\n") || strings.Contains(got, "#EXT-X-ENDLIST") {
+		t.Errorf("unfinished event playlist:\n%s", got)
+	}
+
+	addSegments(t, w, 2*time.Second, 2*time.Second, 2*time.Second, 2*time.Second, 2*time.Second)
+	got = readPlaylist(t, w)
+	if !strings.Contains(got, "#EXT-X-MEDIA-SEQUENCE:3\n") || strings.Count(got, "#EXTINF:") != 6 {
+		t.Errorf("event playlist must keep all segments:\n%s", got)
+	}
+	for seq := 3; seq < 9; seq++ {
+		if name := "segment" + strconv.Itoa(seq) + ".ts"; !exists(w, name) {
+			t.Errorf("segment(%v) is deleted", name)
+		}
+	}
+}
+
+func TestEventTargetDuration(t *testing.T) {
+	w := newTestWriter(t, Config{Type: Event, TargetDuration: 2 * time.Second})
+	addSegments(t, w, 2*time.Second)
+	if got := readPlaylist(t, w); !strings.Contains(got, "#EXT-X-TARGETDURATION:2\n") {
+		t.Fatalf("playlist:\n%s", got)
+	}
+	// a segment longer than target must not change target duration of a published playlist
+	addSegments(t, w, 5*time.Second)
+	if got := readPlaylist(t, w); !strings.Contains(got, "#EXT-X-TARGETDURATION:2\n") {
+		t.Errorf("target duration is changed:\n%s", got)
+	}
+}
+
+func TestLivePlaylist(t *testing.T) {
+	w := newTestWriter(t, Config{
+		Type:            Live,
+		TargetDuration:  2 * time.Second,
+		StartSequence:   10,
+		SegmentTemplate: "live-%03d.ts",
+		ListSize:        3,
+		DeleteSegments:  true,
+		DeleteThreshold: 2,
+	})
+	for i := 0; i < 7; i++ {
+		addSegments(t, w, 2*time.Second)
+	}
+
+	want := "#EXTM3U\n" +
+		"#EXT-X-VERSION:3\n" +
+		"#EXT-X-TARGETDURATION:2\n" +
+		"#EXT-X-MEDIA-SEQUENCE:14\n" +
+		"#EXTINF:2.000000,\nlive-014.ts\n" +
+		"#EXTINF:2.000000,\nlive-015.ts\n" +
+		"#EXTINF:2.000000,\nlive-016.ts\n"
+	if got := readPlaylist(t, w); got != want {
+		t.Errorf("playlist:\n%s\nwant:\n%s", got, want)
+	}
+	// 4 segments are removed from the playlist, the last 2 of them are kept on disk
+	for name, kept := range map[string]bool{
+		"live-010.ts": false,
+		"live-011.ts": false,
+		"live-012.ts": true,
+		"live-013.ts": true,
+		"live-014.ts": true,
+		"live-016.ts": true,
+	} {
+		if exists(w, name) != kept {
+			t.Errorf("segment(%v) exists(%v), want %v", name, !kept, kept)
+		}
+	}
+
+	w.finished = true
+	if err := w.writePlaylist(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readPlaylist(t, w); got != want+"#EXT-X-ENDLIST\n" {
+		t.Errorf("finished playlist:\n%s", got)
+	}
+}
+
+func TestLiveKeepSegments(t *testing.T) {
+	w := newTestWriter(t, Config{Type: Live, ListSize: 2})
+	addSegments(t, w, time.Second, time.Second, time.Second, time.Second)
+	if got := w.Segments(); len(got) != 2 || got[0].Sequence != 2 {
+		t.Errorf("segments %+v", got)
+	}
+	for seq := 0; seq < 4; seq++ {
+		if name := "segment" + strconv.Itoa(seq) + ".ts"; !exists(w, name) {
+			t.Errorf("segment(%v) is deleted without DeleteSegments", name)
+		}
+	}
+}
+
+func TestFMP4Playlist(t *testing.T) {
+	w := newTestWriter(t, Config{Format: FormatFMP4, Type: Event, InitName: "init-0.mp4"})
+	addSegments(t, w, 6*time.Second)
+	got := readPlaylist(t, w)
+	for _, line := range []string{
+		"#EXT-X-VERSION:7\n",
+		"#EXT-X-MAP:URI=\"init-0.mp4\"\n",
+		"#EXTINF:6.000000,\nsegment0.m4s\n",
+	} {
+		if !strings.Contains(got, line) {
+			t.Errorf("playlist does not contain %q:\n%s", line, got)
+		}
+	}
+}
+
+func TestBandwidth(t *testing.T) {
+	w := newTestWriter(t, Config{})
+	for _, seg := range []Segment{
+		{Name: "a.ts", Duration: 2 * time.Second, Size: 1000},
+		{Name: "b.ts", Duration: 2 * time.Second, Size: 3000},
+	} {
+		if err := w.addSegment(seg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if peak, average := w.Bandwidth(); peak != 12000 || average != 8000 {
+		t.Errorf("Bandwidth() = %v, %v, want 12000, 8000", peak, average)
+	}
+}
+
+func TestWriteMasterPlaylist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "master.m3u8")
+	if err := WriteMasterPlaylist(path, nil, nil); err == nil {
+		t.Error("master playlist without variant is written")
+	}
+
+	err := WriteMasterPlaylist(path, []Media{{
+		Type:       "AUDIO",
+		GroupID:    "aac",
+		Name:       "English",
+		Language:   "en",
+		Default:    true,
+		AutoSelect: true,
+		URI:        "audio/index.m3u8",
+	}}, []Variant{{
+		URI:              "720p/index.m3u8",
+		Bandwidth:        3000000,
+		AverageBandwidth: 2500000,
+		Codecs:           "avc1.64001f,mp4a.40.2",
+		Width:            1280,
+		Height:           720,
+		FrameRate:        25,
+		Audio:            "aac",
+	}, {
+		URI:       "360p/index.m3u8",
+		Bandwidth: 800000,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "#EXTM3U\n#EXT-X-VERSION:6\n#EXT-X-INDEPENDENT-SEGMENTS\n" +
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"English\",LANGUAGE=\"en\",DEFAULT=YES,AUTOSELECT=YES,URI=\"audio/index.m3u8\"\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=3000000,AVERAGE-BANDWIDTH=2500000,CODECS=\"avc1.64001f,mp4a.40.2\",RESOLUTION=1280x720,FRAME-RATE=25.000,AUDIO=\"aac\"\n" +
+		"720p/index.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=800000\n" +
+		"360p/index.m3u8\n"
+	if string(data) != want {
+		t.Errorf("master playlist:\n%s\nwant:\n%s", data, want)
+	}
+}
@@ -0,0 +1,34 @@
+#include <stdio.h>
+#include "avlog.h"
+#include "_cgo_export.h"
+
+static int max_level = AV_LOG_INFO;
+
+static void log_callback(void *avcl, int level, const char *fmt, va_list vl)
+{
+    char line[1024];
+    const char *name = NULL;
+    AVClass *avc = avcl ? *(AVClass **)avcl : NULL;
+
+    if (level > max_level)
+        return;
+    if (avc)
+        name = avc->item_name ? avc->item_name(avcl) : avc->class_name;
+    vsnprintf(line, sizeof(line), fmt, vl);
+    logutilAvLog(level, (char *)(name ? name : ""), line);
+}
+
+void avlog_set_max_level(int level)
+{
+    max_level = level;
+}
+
+void avlog_redirect(void)
+{
+    av_log_set_callback(log_callback);
+}
+
+void avlog_restore(void)
+{
+    av_log_set_callback(av_log_default_callback);
+}
@@ -0,0 +1,149 @@
+package logutil
+
+//#cgo pkg-config: libavutil
+//#include "avlog.h"
+import "C"
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/logger"
+)
+
+// AvLogLevel is FFmpeg log level (AV_LOG_xxx)
+type AvLogLevel int
+
+// AV_LOG_xxx
+const (
+	AvLogQuiet   AvLogLevel = -8
+	AvLogPanic   AvLogLevel = 0
+	AvLogFatal   AvLogLevel = 8
+	AvLogError   AvLogLevel = 16
+	AvLogWarning AvLogLevel = 24
+	AvLogInfo    AvLogLevel = 32
+	AvLogVerbose AvLogLevel = 40
+	AvLogDebug   AvLogLevel = 48
+	AvLogTrace   AvLogLevel = 56
+)
+
+var avLogLevelNames = map[string]AvLogLevel{
+	"quiet":   AvLogQuiet,
+	"panic":   AvLogPanic,
+	"fatal":   AvLogFatal,
+	"error":   AvLogError,
+	"warning": AvLogWarning,
+	"info":    AvLogInfo,
+	"verbose": AvLogVerbose,
+	"debug":   AvLogDebug,
+	"trace":   AvLogTrace,
+}
+
+// ParseAvLogLevel parse level name as accepted by ffmpeg -loglevel, e.g. "info", "debug"
+func ParseAvLogLevel(s string) (AvLogLevel, error) {
+	level, ok := avLogLevelNames[strings.ToLower(s)]
+	if !ok {
+		return AvLogQuiet, fmt.Errorf("ParseAvLogLevel: unknown level(%v)", s)
+	}
+	return level, nil
+}
+
+// avLog hold the state shared with the av_log callback
+var avLog = struct {
+	sync.Mutex
+	level       AvLogLevel
+	classLevels map[string]AvLogLevel
+	pending     map[string]string
+}{
+	level:       AvLogInfo,
+	classLevels: map[string]AvLogLevel{},
+	pending:     map[string]string{},
+}
+
+// RedirectAvLog forward av_log output into the Go logger instead of stderr,
+// messages are prefixed with the class name, e.g. [h264], [flv], [AVIOContext]
+func RedirectAvLog() {
+	avLog.Lock()
+	defer avLog.Unlock()
+	updateAvMaxLevel()
+	C.avlog_redirect()
+}
+
+// RestoreAvLog restore the default av_log output to stderr
+func RestoreAvLog() {
+	C.avlog_restore()
+	avLog.Lock()
+	defer avLog.Unlock()
+	avLog.pending = map[string]string{}
+}
+
+// SetAvLogLevel set FFmpeg log level of classes without their own level
+func SetAvLogLevel(level AvLogLevel) {
+	avLog.Lock()
+	defer avLog.Unlock()
+	avLog.level = level
+	C.av_log_set_level(C.int(level))
+	updateAvMaxLevel()
+}
+
+// SetAvClassLevel set FFmpeg log level of the class named name, e.g. "h264",
+// it may be more or less verbose than the level set by SetAvLogLevel
+func SetAvClassLevel(name string, level AvLogLevel) {
+	avLog.Lock()
+	defer avLog.Unlock()
+	avLog.classLevels[name] = level
+	updateAvMaxLevel()
+}
+
+// ResetAvClassLevel make the class named name follow the level set by SetAvLogLevel again
+func ResetAvClassLevel(name string) {
+	avLog.Lock()
+	defer avLog.Unlock()
+	delete(avLog.classLevels, name)
+	updateAvMaxLevel()
+}
+
+// updateAvMaxLevel let the C callback drop messages no level is interested in, avLog must be locked
+func updateAvMaxLevel() {
+	max := avLog.level
+	for _, level := range avLog.classLevels {
+		if level > max {
+			max = level
+		}
+	}
+	C.avlog_set_max_level(C.int(max))
+}
+
+// avLogLine filter and buffer one av_log message, a line may be written by several calls
+func avLogLine(level AvLogLevel, class, msg string) {
+	avLog.Lock()
+	maxLevel, ok := avLog.classLevels[class]
+	if !ok {
+		maxLevel = avLog.level
+	}
+	if level > maxLevel {
+		avLog.Unlock()
+		return
+	}
+	msg = avLog.pending[class] + msg
+	if !strings.HasSuffix(msg, "\n") {
+		avLog.pending[class] = msg
+		avLog.Unlock()
+		return
+	}
+	delete(avLog.pending, class)
+	avLog.Unlock()
+
+	msg = strings.TrimRight(msg, "\n")
+	if class != "" {
+		msg = "[" + class + "] " + msg
+	}
+	switch {
+	case level <= AvLogError:
+		logger.ErrorDepth(2, msg)
+	case level <= AvLogWarning:
+		logger.WarningDepth(2, msg)
+	default:
+		logger.InfoDepth(2, msg)
+	}
+}
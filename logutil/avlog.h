@@ -0,0 +1,15 @@
+#ifndef FFMPEG_DEMO_AVLOG_H
+#define FFMPEG_DEMO_AVLOG_H
+
+#include <libavutil/log.h>
+
+// set the most verbose level passed back to Go, messages above are dropped in C
+void avlog_set_max_level(int level);
+
+// forward av_log messages to Go
+void avlog_redirect(void);
+
+// restore the default av_log callback writing to stderr
+void avlog_restore(void);
+
+#endif
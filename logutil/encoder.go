@@ -0,0 +1,92 @@
+package logutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeFormat is the layout of entry time written by encoders
+const TimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// JSONEncoder encode entry as one JSON object per line,
+// with keys "time", "level", "msg" followed by the fields
+type JSONEncoder struct{}
+
+// Encode implement Encoder
+func (JSONEncoder) Encode(buf *bytes.Buffer, e *Entry) error {
+	buf.WriteString(`{"time":`)
+	buf.WriteString(strconv.Quote(e.Time.Format(TimeFormat)))
+	buf.WriteString(`,"level":`)
+	buf.WriteString(strconv.Quote(e.Level.String()))
+	buf.WriteString(`,"msg":`)
+	if err := writeJSON(buf, e.Message); err != nil {
+		return err
+	}
+	for _, f := range e.Fields {
+		buf.WriteByte(',')
+		if err := writeJSON(buf, f.Key); err != nil {
+			return err
+		}
+		buf.WriteByte(':')
+		if err := writeJSON(buf, fieldValue(f.Value)); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+func writeJSON(buf *bytes.Buffer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("JSONEncoder Encode: marshal(%T) error(%v)", v, err)
+	}
+	buf.Write(b)
+	return nil
+}
+
+// TextEncoder encode entry as `time LEVEL msg key=value ...`
+type TextEncoder struct {
+	// NoTime omit time, for outputs adding their own. The level is always written since
+	// outputs such as google/logger write debug entries as info
+	NoTime bool
+}
+
+// Encode implement Encoder
+func (t TextEncoder) Encode(buf *bytes.Buffer, e *Entry) error {
+	if !t.NoTime {
+		buf.WriteString(e.Time.Format(TimeFormat))
+		buf.WriteByte(' ')
+	}
+	buf.WriteString(strings.ToUpper(e.Level.String()))
+	buf.WriteByte(' ')
+	buf.WriteString(e.Message)
+	for _, f := range e.Fields {
+		buf.WriteByte(' ')
+		buf.WriteString(f.Key)
+		buf.WriteByte('=')
+		s := fmt.Sprint(fieldValue(f.Value))
+		if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+			s = strconv.Quote(s)
+		}
+		buf.WriteString(s)
+	}
+	return nil
+}
+
+// fieldValue convert values without a useful JSON form
+func fieldValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
@@ -0,0 +1,11 @@
+package logutil
+
+//#include <stdint.h>
+import "C"
+
+// logutilAvLog is called by the av_log callback with the formatted message
+//
+//export logutilAvLog
+func logutilAvLog(level C.int, class *C.char, msg *C.char) {
+	avLogLine(AvLogLevel(level), C.GoString(class), C.GoString(msg))
+}
@@ -0,0 +1,186 @@
+package logutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/logger"
+)
+
+// Level is severity of a structured log entry
+type Level int
+
+// Levels of structured log entries
+const (
+	DebugLevel Level = iota - 1
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+// String return lower case level name
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// ParseLevel parse level name, e.g. "debug", "info", "warn", "error"
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return DebugLevel, nil
+	case "info":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	}
+	return InfoLevel, fmt.Errorf("ParseLevel: unknown level(%v)", s)
+}
+
+// Field is a key/value pair attached to log entries
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F create a Field
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Entry is a structured log entry passed to Encoder
+type Entry struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	Fields  []Field
+}
+
+// Encoder serialize Entry to one line, without the trailing newline
+type Encoder interface {
+	Encode(buf *bytes.Buffer, e *Entry) error
+}
+
+// sink is shared by a Logger and the children created by With
+type sink struct {
+	mu    sync.Mutex
+	level Level
+	enc   Encoder
+	w     io.Writer
+}
+
+// Logger write leveled log entries with key/value fields, safe for concurrent use
+type Logger struct {
+	s      *sink
+	fields []Field
+}
+
+// New create a Logger writing entries not below level to w, encoded by enc
+func New(w io.Writer, enc Encoder, level Level) *Logger {
+	return &Logger{s: &sink{level: level, enc: enc, w: w}}
+}
+
+var defaultLogger = &Logger{s: &sink{level: InfoLevel, enc: TextEncoder{NoTime: true}}}
+
+// Default return the Logger forwarding to the global google/logger set up by Init,
+// it is used by Decoder, Encoder, Muxer and Demuxer when no Logger is set.
+// Its level is InfoLevel, call Default().SetLevel(DebugLevel) to write debug entries
+// (e.g. per packet timestamp fixes)
+func Default() *Logger {
+	return defaultLogger
+}
+
+// With return a child Logger adding fields to every entry
+func (l *Logger) With(fields ...Field) *Logger {
+	child := &Logger{s: l.s, fields: make([]Field, 0, len(l.fields)+len(fields))}
+	child.fields = append(child.fields, l.fields...)
+	child.fields = append(child.fields, fields...)
+	return child
+}
+
+// SetLevel change level of l and all Loggers sharing its output
+func (l *Logger) SetLevel(level Level) {
+	l.s.mu.Lock()
+	l.s.level = level
+	l.s.mu.Unlock()
+}
+
+// Enabled report whether entries of level are written
+func (l *Logger) Enabled(level Level) bool {
+	l.s.mu.Lock()
+	defer l.s.mu.Unlock()
+	return level >= l.s.level
+}
+
+// Debug write a debug entry
+func (l *Logger) Debug(msg string, fields ...Field) {
+	l.log(DebugLevel, msg, fields)
+}
+
+// Info write a info entry
+func (l *Logger) Info(msg string, fields ...Field) {
+	l.log(InfoLevel, msg, fields)
+}
+
+// Warn write a warn entry
+func (l *Logger) Warn(msg string, fields ...Field) {
+	l.log(WarnLevel, msg, fields)
+}
+
+// Error write a error entry
+func (l *Logger) Error(msg string, fields ...Field) {
+	l.log(ErrorLevel, msg, fields)
+}
+
+func (l *Logger) log(level Level, msg string, fields []Field) {
+	if !l.Enabled(level) {
+		return
+	}
+	e := &Entry{
+		Time:    time.Now(),
+		Level:   level,
+		Message: msg,
+		Fields:  l.fields,
+	}
+	if len(fields) > 0 {
+		e.Fields = append(append(make([]Field, 0, len(l.fields)+len(fields)), l.fields...), fields...)
+	}
+
+	var buf bytes.Buffer
+	if err := l.s.enc.Encode(&buf, e); err != nil {
+		logger.Errorf("Logger log: encode entry error(%v)", err)
+		return
+	}
+	if l.s.w == nil {
+		switch level {
+		case ErrorLevel:
+			logger.ErrorDepth(3, buf.String())
+		case WarnLevel:
+			logger.WarningDepth(3, buf.String())
+		default:
+			logger.InfoDepth(3, buf.String())
+		}
+		return
+	}
+	buf.WriteByte('\n')
+	l.s.mu.Lock()
+	defer l.s.mu.Unlock()
+	if _, err := l.s.w.Write(buf.Bytes()); err != nil {
+		logger.Errorf("Logger log: write entry error(%v)", err)
+	}
+}
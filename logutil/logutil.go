@@ -1,3 +1,4 @@
+// Package logutil set up logging of Go code and libav*
 package logutil
 
 import (
@@ -17,7 +18,8 @@ func Init(verbose, systemLog bool, logPath string) {
 	pLogger = logger.Init("FFmpegDemoLogger", verbose, systemLog, file)
 }
 
-// Close close logger
+// Close close logger, av_log output is restored to stderr
 func Close() {
+	RestoreAvLog()
 	pLogger.Close()
 }
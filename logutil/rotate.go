@@ -0,0 +1,156 @@
+package logutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is appended to the rotated file name
+const backupTimeFormat = "20060102-150405.000"
+
+// RotateConfig configure RotateWriter
+type RotateConfig struct {
+	// Path of the current log file, rotated files are named Path.<time>
+	Path string
+	// MaxSize rotate before the file grows over MaxSize bytes, 0 disable size rotation
+	MaxSize int64
+	// Interval rotate when the file is older than Interval, 0 disable time rotation
+	Interval time.Duration
+	// MaxBackups remove oldest rotated files over MaxBackups, 0 keep all
+	MaxBackups int
+}
+
+// RotateWriter is a io.WriteCloser appending to a file rotated by size or time,
+// safe for concurrent use
+type RotateWriter struct {
+	cfg    RotateConfig
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// NewRotateWriter open cfg.Path for append, creating it if needed
+func NewRotateWriter(cfg RotateConfig) (w *RotateWriter, err error) {
+	if cfg.Path == "" {
+		err = fmt.Errorf("NewRotateWriter: path is empty")
+		return
+	}
+	w = &RotateWriter{cfg: cfg}
+	if err = w.open(); err != nil {
+		w = nil
+	}
+	return
+}
+
+// Write implement io.Writer, a single write is never split across files
+func (w *RotateWriter) Write(p []byte) (n int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		err = fmt.Errorf("RotateWriter Write: writer is closed")
+		return
+	}
+	if w.needRotate(int64(len(p))) {
+		if err = w.rotate(); err != nil {
+			return
+		}
+	}
+	n, err = w.file.Write(p)
+	w.size += int64(n)
+	return
+}
+
+// Rotate close the current file and start a new one
+func (w *RotateWriter) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rotate()
+}
+
+// Close close the current file
+func (w *RotateWriter) Close() (err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	return
+}
+
+func (w *RotateWriter) needRotate(n int64) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.cfg.MaxSize > 0 && w.size+n > w.cfg.MaxSize {
+		return true
+	}
+	return w.cfg.Interval > 0 && time.Since(w.opened) >= w.cfg.Interval
+}
+
+func (w *RotateWriter) open() error {
+	file, err := os.OpenFile(w.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		return fmt.Errorf("RotateWriter open: open file(%v) error(%v)", w.cfg.Path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("RotateWriter open: stat file(%v) error(%v)", w.cfg.Path, err)
+	}
+	w.file = file
+	w.size = info.Size()
+	w.opened = time.Now()
+	return nil
+}
+
+func (w *RotateWriter) rotate() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return fmt.Errorf("RotateWriter rotate: close file(%v) error(%v)", w.cfg.Path, err)
+		}
+		w.file = nil
+	}
+	backup := w.cfg.Path + "." + time.Now().Format(backupTimeFormat)
+	if err := os.Rename(w.cfg.Path, backup); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("RotateWriter rotate: rename file(%v) error(%v)", w.cfg.Path, err)
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	return w.removeBackups()
+}
+
+// removeBackups remove the oldest rotated files over MaxBackups
+func (w *RotateWriter) removeBackups() error {
+	if w.cfg.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(w.cfg.Path + ".*")
+	if err != nil {
+		return fmt.Errorf("RotateWriter removeBackups: glob error(%v)", err)
+	}
+	prefix := w.cfg.Path + "."
+	valid := backups[:0]
+	for _, b := range backups {
+		if _, err := time.Parse(backupTimeFormat, strings.TrimPrefix(b, prefix)); err == nil {
+			valid = append(valid, b)
+		}
+	}
+	// time format sort in time order
+	sort.Strings(valid)
+	for len(valid) > w.cfg.MaxBackups {
+		if err := os.Remove(valid[0]); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("RotateWriter removeBackups: remove file(%v) error(%v)", valid[0], err)
+		}
+		valid = valid[1:]
+	}
+	return nil
+}
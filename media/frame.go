@@ -0,0 +1,289 @@
+package media
+
+/*
+#cgo pkg-config: libavutil
+#include <libavutil/frame.h>
+#include <libavutil/pixdesc.h>
+#include <libavutil/samplefmt.h>
+#include <libavutil/channel_layout.h>
+#include <libavutil/mathematics.h>
+
+// height in rows of a video plane
+static int frame_plane_rows(const AVFrame *f, int plane)
+{
+    const AVPixFmtDescriptor *desc = av_pix_fmt_desc_get(f->format);
+    if (!desc)
+        return 0;
+    if (plane == 1 || plane == 2)
+        return AV_CEIL_RSHIFT(f->height, desc->log2_chroma_h);
+    return f->height;
+}
+
+// size in bytes of an audio plane
+static int frame_audio_plane_size(const AVFrame *f)
+{
+    int planar = av_sample_fmt_is_planar(f->format);
+    int size = av_samples_get_buffer_size(NULL, f->channels, f->nb_samples, f->format, 1);
+    if (size < 0)
+        return 0;
+    return planar ? size / f->channels : size;
+}
+*/
+import "C"
+import (
+	"math"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/xueqing/ffmpeg-demo/averror"
+	"github.com/xueqing/ffmpeg-demo/util"
+	"github.com/xueqing/goav/libavcodec"
+	"github.com/xueqing/goav/libavutil"
+)
+
+// liveFrames count frames allocated and not freed
+var liveFrames int64
+
+// LiveFrames Return the number of frames not freed yet, can be used to detect leak
+func LiveFrames() int64 {
+	return atomic.LoadInt64(&liveFrames)
+}
+
+// Frame own an AVFrame and the time base of its pts.
+// A Frame must be freed by Free once; Unref only drops the buffer references
+// so that the Frame can be reused
+type Frame struct {
+	pFrame   *libavutil.AvFrame
+	timeBase libavcodec.AvRational
+}
+
+// NewFrame allocate an empty frame
+func NewFrame() *Frame {
+	pFrame := libavutil.AvFrameAlloc()
+	if pFrame == nil {
+		return nil
+	}
+	atomic.AddInt64(&liveFrames, 1)
+	return &Frame{pFrame: pFrame}
+}
+
+// NewVideoFrame allocate a frame with buffers for a picture
+func NewVideoFrame(width, height int, pixFmt libavcodec.AvPixelFormat) (f *Frame, err error) {
+	if f = NewFrame(); f == nil {
+		err = averror.New("NewVideoFrame: alloc frame", averror.CodeNoMem)
+		return
+	}
+	pFrame := f.cFrame()
+	pFrame.width, pFrame.height = C.int(width), C.int(height)
+	pFrame.format = C.int(pixFmt)
+	if ret := libavutil.AvFrameGetBuffer(f.pFrame, 0); ret < 0 {
+		err = averror.New("NewVideoFrame: alloc buffer", ret)
+		f.Free()
+		f = nil
+	}
+	return
+}
+
+// NewAudioFrame allocate a frame with buffers for nbSamples samples per channel
+func NewAudioFrame(nbSamples int, sampleFmt libavcodec.AvSampleFormat, sampleRate int,
+	channelLayout uint64) (f *Frame, err error) {
+	if f = NewFrame(); f == nil {
+		err = averror.New("NewAudioFrame: alloc frame", averror.CodeNoMem)
+		return
+	}
+	pFrame := f.cFrame()
+	pFrame.nb_samples = C.int(nbSamples)
+	pFrame.format = C.int(sampleFmt)
+	pFrame.sample_rate = C.int(sampleRate)
+	pFrame.channel_layout = C.uint64_t(channelLayout)
+	pFrame.channels = C.av_get_channel_layout_nb_channels(C.uint64_t(channelLayout))
+	if ret := libavutil.AvFrameGetBuffer(f.pFrame, 0); ret < 0 {
+		err = averror.New("NewAudioFrame: alloc buffer", ret)
+		f.Free()
+		f = nil
+	}
+	return
+}
+
+// Free unreference the buffers and free the frame
+func (f *Frame) Free() {
+	if f.pFrame != nil {
+		libavutil.AvFrameFree(f.pFrame)
+		f.pFrame = nil
+		atomic.AddInt64(&liveFrames, -1)
+	}
+}
+
+// AvFrame Return the underlying AVFrame, which is still owned by f
+func (f *Frame) AvFrame() *libavutil.AvFrame {
+	return f.pFrame
+}
+
+// Ref make f reference the buffers and copy properties of src, f is unreferenced first
+func (f *Frame) Ref(src *Frame) (err error) {
+	libavutil.AvFrameUnref(f.pFrame)
+	if ret := libavutil.AvFrameRef(f.pFrame, src.pFrame); ret < 0 {
+		err = averror.New("Frame Ref", ret)
+		return
+	}
+	f.timeBase = src.timeBase
+	return
+}
+
+// Unref drop the buffer references and reset properties, f can be reused
+func (f *Frame) Unref() {
+	libavutil.AvFrameUnref(f.pFrame)
+}
+
+// Clone create a new Frame referencing the same buffers as f, must be freed by Free
+func (f *Frame) Clone() (c *Frame, err error) {
+	if c = NewFrame(); c == nil {
+		err = averror.New("Frame Clone: alloc frame", averror.CodeNoMem)
+		return
+	}
+	if err = c.Ref(f); err != nil {
+		c.Free()
+		c = nil
+	}
+	return
+}
+
+// MakeWritable copy the buffers if they are shared, must be called before writing planes
+func (f *Frame) MakeWritable() (err error) {
+	if ret := libavutil.AvFrameMakeWritable(f.pFrame); ret < 0 {
+		err = averror.New("Frame MakeWritable", ret)
+	}
+	return
+}
+
+// IsVideo Return whether f describes a picture
+func (f *Frame) IsVideo() bool {
+	return f.cFrame().width > 0 && f.cFrame().height > 0
+}
+
+// Width Return picture width
+func (f *Frame) Width() int {
+	return int(f.cFrame().width)
+}
+
+// Height Return picture height
+func (f *Frame) Height() int {
+	return int(f.cFrame().height)
+}
+
+// PixelFormat Return pixel format of picture
+func (f *Frame) PixelFormat() libavcodec.AvPixelFormat {
+	return libavcodec.AvPixelFormat(f.cFrame().format)
+}
+
+// SampleFormat Return sample format of audio
+func (f *Frame) SampleFormat() libavcodec.AvSampleFormat {
+	return libavcodec.AvSampleFormat(f.cFrame().format)
+}
+
+// NbSamples Return number of audio samples per channel
+func (f *Frame) NbSamples() int {
+	return int(f.cFrame().nb_samples)
+}
+
+// SampleRate Return audio sample rate
+func (f *Frame) SampleRate() int {
+	return int(f.cFrame().sample_rate)
+}
+
+// Channels Return number of audio channels
+func (f *Frame) Channels() int {
+	return int(f.cFrame().channels)
+}
+
+// ChannelLayout Return audio channel layout (AV_CH_LAYOUT_xxx)
+func (f *Frame) ChannelLayout() uint64 {
+	return uint64(f.cFrame().channel_layout)
+}
+
+// IsKeyframe Return whether f is a keyframe
+func (f *Frame) IsKeyframe() bool {
+	return f.cFrame().key_frame != 0
+}
+
+// Pts Return pts in time base, util.AvNoPtsValue if undefined
+func (f *Frame) Pts() int64 {
+	return f.pFrame.Pts()
+}
+
+// SetPts Set pts in time base
+func (f *Frame) SetPts(pts int64) {
+	f.pFrame.SetPts(pts)
+}
+
+// TimeBase Return the time base of pts, 0/0 if unknown
+func (f *Frame) TimeBase() libavcodec.AvRational {
+	return f.timeBase
+}
+
+// SetTimeBase Set the time base of pts without changing it
+func (f *Frame) SetTimeBase(tb libavcodec.AvRational) {
+	f.timeBase = tb
+}
+
+// RescalePts Convert pts from the current time base to tb and set time base to tb
+func (f *Frame) RescalePts(tb libavcodec.AvRational) {
+	if pts := f.Pts(); pts != util.AvNoPtsValue && f.timeBase.Den() != 0 {
+		f.SetPts(int64(C.av_rescale_q(C.int64_t(pts),
+			C.AVRational{num: C.int(f.timeBase.Num()), den: C.int(f.timeBase.Den())},
+			C.AVRational{num: C.int(tb.Num()), den: C.int(tb.Den())})))
+	}
+	f.timeBase = tb
+}
+
+// PtsSeconds Return pts in seconds, NaN if pts is undefined or time base is unknown
+func (f *Frame) PtsSeconds() float64 {
+	pts := f.Pts()
+	if pts == util.AvNoPtsValue || f.timeBase.Den() == 0 {
+		return math.NaN()
+	}
+	return float64(pts) * float64(f.timeBase.Num()) / float64(f.timeBase.Den())
+}
+
+// NbPlanes Return number of data planes
+func (f *Frame) NbPlanes() int {
+	pFrame := f.cFrame()
+	if f.IsVideo() {
+		if n := C.av_pix_fmt_count_planes(C.enum_AVPixelFormat(pFrame.format)); n > 0 {
+			return int(n)
+		}
+		return 0
+	}
+	if C.av_sample_fmt_is_planar(C.enum_AVSampleFormat(pFrame.format)) != 0 {
+		return int(pFrame.channels)
+	}
+	return 1
+}
+
+// Linesize Return size in bytes of a picture row of plane, including padding
+func (f *Frame) Linesize(plane int) int {
+	if plane < 0 || plane >= len(f.cFrame().linesize) {
+		return 0
+	}
+	return int(f.cFrame().linesize[plane])
+}
+
+// Plane Return data of plane, nil if plane does not exist. For video it holds
+// rows of Linesize(plane) bytes; for audio the samples of one channel (planar)
+// or all channels interleaved (packed).
+// It shares memory with the frame, call MakeWritable before modifying
+func (f *Frame) Plane(plane int) []byte {
+	if plane < 0 || plane >= f.NbPlanes() {
+		return nil
+	}
+	pFrame := f.cFrame()
+	data := (*[1 << 20]*C.uint8_t)(unsafe.Pointer(pFrame.extended_data))[plane]
+	if f.IsVideo() {
+		return cBytes(unsafe.Pointer(data), f.Linesize(plane)*int(C.frame_plane_rows(pFrame, C.int(plane))))
+	}
+	return cBytes(unsafe.Pointer(data), int(C.frame_audio_plane_size(pFrame)))
+}
+
+func (f *Frame) cFrame() *C.AVFrame {
+	return (*C.AVFrame)(unsafe.Pointer(f.pFrame))
+}
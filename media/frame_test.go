@@ -0,0 +1,264 @@
+package media
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/xueqing/goav/libavcodec"
+)
+
+// AV_SAMPLE_FMT_S16, AV_SAMPLE_FMT_S16P and AV_CH_LAYOUT_STEREO, not exported by goav
+const (
+	sampleFmtS16        = libavcodec.AvSampleFormat(1)
+	sampleFmtS16p       = libavcodec.AvSampleFormat(6)
+	channelLayoutStereo = 0x3
+)
+
+func TestFrameLeak(t *testing.T) {
+	start := LiveFrames()
+
+	video, err := NewVideoFrame(64, 48, pixFmtYuv420p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audio, err := NewAudioFrame(1024, sampleFmtS16, 48000, channelLayoutStereo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := video.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Width() != 64 || c.Height() != 48 {
+		t.Errorf("clone size %vx%v", c.Width(), c.Height())
+	}
+	r := NewFrame()
+	if err = r.Ref(audio); err != nil {
+		t.Fatal(err)
+	}
+	if r.NbSamples() != 1024 || r.Channels() != 2 {
+		t.Errorf("ref samples(%v) channels(%v)", r.NbSamples(), r.Channels())
+	}
+	r.Unref()
+	if got := LiveFrames() - start; got != 4 {
+		t.Errorf("live frames(%v), want 4", got)
+	}
+
+	video.Free()
+	audio.Free()
+	c.Free()
+	r.Free()
+	video.Free()
+	if got := LiveFrames(); got != start {
+		t.Errorf("live frames(%v) after free, want %v", got, start)
+	}
+}
+
+func TestFrameCloneError(t *testing.T) {
+	start := LiveFrames()
+
+	// a frame without buffers or format can not be referenced
+	empty := NewFrame()
+	c, err := empty.Clone()
+	if err == nil || c != nil {
+		t.Fatalf("Clone of empty frame return frame(%v) error(%v)", c, err)
+	}
+	if got := LiveFrames() - start; got != 1 {
+		t.Errorf("live frames(%v) after failed Clone, want 1", got)
+	}
+	empty.Free()
+	if got := LiveFrames(); got != start {
+		t.Errorf("live frames(%v) after free, want %v", got, start)
+	}
+}
+
+func TestPlane(t *testing.T) {
+	video, err := NewVideoFrame(64, 48, pixFmtYuv420p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer video.Free()
+	if video.NbPlanes() != 3 {
+		t.Fatalf("video planes(%v), want 3", video.NbPlanes())
+	}
+	for plane, rows := range []int{48, 24, 24} {
+		if got, want := len(video.Plane(plane)), video.Linesize(plane)*rows; got != want || want == 0 {
+			t.Errorf("video plane(%v) size(%v), want %v", plane, got, want)
+		}
+	}
+	if video.Plane(3) != nil || video.Plane(-1) != nil {
+		t.Error("plane out of range is not nil")
+	}
+
+	packed, err := NewAudioFrame(1024, sampleFmtS16, 48000, channelLayoutStereo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer packed.Free()
+	if packed.NbPlanes() != 1 || len(packed.Plane(0)) != 1024*2*2 {
+		t.Errorf("packed planes(%v) size(%v), want 1 plane of %v", packed.NbPlanes(), len(packed.Plane(0)), 1024*2*2)
+	}
+
+	planar, err := NewAudioFrame(1024, sampleFmtS16p, 48000, channelLayoutStereo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer planar.Free()
+	if planar.NbPlanes() != 2 || len(planar.Plane(0)) != 1024*2 || len(planar.Plane(1)) != 1024*2 {
+		t.Errorf("planar planes(%v) size(%v), want 2 planes of %v", planar.NbPlanes(), len(planar.Plane(1)), 1024*2)
+	}
+}
+
+func TestChannelSamples(t *testing.T) {
+	values := [][]int16{
+		{0, 1 << 14, -1 << 15, 1<<15 - 1},
+		{0, -1 << 14, 1<<15 - 1, -1 << 15},
+	}
+	want := [][]float32{
+		{0, 0.5, -1, float32(1<<15-1) / (1 << 15)},
+		{0, -0.5, float32(1<<15-1) / (1 << 15), -1},
+	}
+
+	for _, sampleFmt := range []libavcodec.AvSampleFormat{sampleFmtS16, sampleFmtS16p} {
+		f, err := NewAudioFrame(len(values[0]), sampleFmt, 48000, channelLayoutStereo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// samples are native endian, little endian on supported hosts
+		for ch := range values {
+			for i, v := range values[ch] {
+				if sampleFmt == sampleFmtS16 {
+					binary.LittleEndian.PutUint16(f.Plane(0)[4*i+2*ch:], uint16(v))
+				} else {
+					binary.LittleEndian.PutUint16(f.Plane(ch)[2*i:], uint16(v))
+				}
+			}
+		}
+		for ch := range want {
+			samples, err := f.ChannelSamples(ch)
+			if err != nil {
+				t.Fatalf("format(%v) ChannelSamples(%v) error(%v)", sampleFmt, ch, err)
+			}
+			if len(samples) != len(want[ch]) {
+				t.Fatalf("format(%v) channel(%v) samples %v, want %v", sampleFmt, ch, samples, want[ch])
+			}
+			for i := range samples {
+				if samples[i] != want[ch][i] {
+					t.Errorf("format(%v) channel(%v) samples %v, want %v", sampleFmt, ch, samples, want[ch])
+					break
+				}
+			}
+		}
+		if _, err = f.ChannelSamples(2); err == nil {
+			t.Errorf("format(%v) ChannelSamples of channel 2 is accepted", sampleFmt)
+		}
+		f.Free()
+	}
+
+	video, err := NewVideoFrame(16, 16, pixFmtGray8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer video.Free()
+	if _, err = video.ChannelSamples(0); err == nil {
+		t.Error("ChannelSamples of video frame is accepted")
+	}
+}
+
+// roundTrip convert img to a frame and back
+func roundTrip(t *testing.T, img image.Image, pixFmt libavcodec.AvPixelFormat) image.Image {
+	t.Helper()
+	f, err := FromImage(img)
+	if err != nil {
+		t.Fatalf("FromImage(%T) error(%v)", img, err)
+	}
+	defer f.Free()
+	if f.PixelFormat() != pixFmt || f.Width() != img.Bounds().Dx() || f.Height() != img.Bounds().Dy() {
+		t.Errorf("FromImage(%T) frame format(%v) size %vx%v", img, f.PixelFormat(), f.Width(), f.Height())
+	}
+	got, err := f.ToImage()
+	if err != nil {
+		t.Fatalf("ToImage of %T error(%v)", img, err)
+	}
+	return got
+}
+
+func TestImageRoundTrip(t *testing.T) {
+	// odd size for rounding of chroma planes
+	rect := image.Rect(0, 0, 33, 17)
+
+	ycc := image.NewYCbCr(rect, image.YCbCrSubsampleRatio420)
+	for i := range ycc.Y {
+		ycc.Y[i] = uint8(i)
+	}
+	for i := range ycc.Cb {
+		ycc.Cb[i], ycc.Cr[i] = uint8(3*i), uint8(255-i)
+	}
+	got, ok := roundTrip(t, ycc, pixFmtYuvj420p).(*image.YCbCr)
+	if !ok || got.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+		t.Fatalf("ToImage return %T, want 4:2:0 *image.YCbCr", got)
+	}
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			if got.YCbCrAt(x, y) != ycc.YCbCrAt(x, y) {
+				t.Fatalf("YCbCr at (%v, %v) = %v, want %v", x, y, got.YCbCrAt(x, y), ycc.YCbCrAt(x, y))
+			}
+		}
+	}
+
+	gray := image.NewGray(rect)
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(7 * i)
+	}
+	if got, ok := roundTrip(t, gray, pixFmtGray8).(*image.Gray); !ok || !samePixels(got, gray) {
+		t.Errorf("gray round trip return %T", got)
+	}
+
+	// sub image with an offset origin
+	rgba := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for i := range rgba.Pix {
+		rgba.Pix[i] = uint8(i)
+	}
+	sub := rgba.SubImage(image.Rect(5, 2, 38, 19))
+	if got, ok := roundTrip(t, sub, pixFmtRgba).(*image.RGBA); !ok || !samePixels(got, sub) {
+		t.Errorf("rgba round trip return %T", got)
+	}
+}
+
+// samePixels compare colors of images of the same size, ignoring origin
+func samePixels(a, b image.Image) bool {
+	ra, rb := a.Bounds(), b.Bounds()
+	if ra.Dx() != rb.Dx() || ra.Dy() != rb.Dy() {
+		return false
+	}
+	for y := 0; y < ra.Dy(); y++ {
+		for x := 0; x < ra.Dx(); x++ {
+			ca := color.RGBAModel.Convert(a.At(ra.Min.X+x, ra.Min.Y+y))
+			cb := color.RGBAModel.Convert(b.At(rb.Min.X+x, rb.Min.Y+y))
+			if ca != cb {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestToImageLimitedRange(t *testing.T) {
+	f, err := NewVideoFrame(16, 16, pixFmtYuv420p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Free()
+	if img, err := f.ToImage(); err == nil {
+		t.Errorf("ToImage of limited range yuv420p return %T", img)
+	}
+
+	f.cFrame().color_range = colorRangeJPEG
+	if img, err := f.ToImage(); err != nil {
+		t.Errorf("ToImage of yuv420p tagged full range error(%v)", err)
+	} else if _, ok := img.(*image.YCbCr); !ok {
+		t.Errorf("ToImage return %T, want *image.YCbCr", img)
+	}
+}
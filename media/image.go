@@ -0,0 +1,233 @@
+package media
+
+//#cgo pkg-config: libavutil
+//#include <libavutil/frame.h>
+//#include <libavutil/pixfmt.h>
+//#include <libavutil/samplefmt.h>
+import "C"
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"unsafe"
+
+	"github.com/xueqing/goav/libavcodec"
+)
+
+// AV_PIX_FMT_xxx supported by image conversion
+const (
+	pixFmtYuv420p  = C.AV_PIX_FMT_YUV420P
+	pixFmtYuv422p  = C.AV_PIX_FMT_YUV422P
+	pixFmtYuv444p  = C.AV_PIX_FMT_YUV444P
+	pixFmtYuv440p  = C.AV_PIX_FMT_YUV440P
+	pixFmtYuv411p  = C.AV_PIX_FMT_YUV411P
+	pixFmtYuv410p  = C.AV_PIX_FMT_YUV410P
+	pixFmtYuvj420p = C.AV_PIX_FMT_YUVJ420P
+	pixFmtYuvj422p = C.AV_PIX_FMT_YUVJ422P
+	pixFmtYuvj444p = C.AV_PIX_FMT_YUVJ444P
+	pixFmtYuvj440p = C.AV_PIX_FMT_YUVJ440P
+	pixFmtGray8    = C.AV_PIX_FMT_GRAY8
+	pixFmtRgba     = C.AV_PIX_FMT_RGBA
+)
+
+// AVCOL_RANGE_JPEG, full range YCbCr as used by image.YCbCr
+const colorRangeJPEG = C.AVCOL_RANGE_JPEG
+
+// ToImage copy the picture into an image.Image: *image.YCbCr for planar yuv 8 bits formats
+// of full range (yuvj or AVCOL_RANGE_JPEG), *image.Gray for gray, *image.RGBA for rgba.
+// Other formats and limited range yuv (most decoded video) must be converted first
+// (e.g. to yuvj420p or rgba by scale package)
+func (f *Frame) ToImage() (img image.Image, err error) {
+	if !f.IsVideo() {
+		err = fmt.Errorf("Frame ToImage: not a video frame")
+		return
+	}
+	rect := image.Rect(0, 0, f.Width(), f.Height())
+
+	switch pixFmt := f.PixelFormat(); pixFmt {
+	case pixFmtGray8:
+		dst := image.NewGray(rect)
+		copyPlane(dst.Pix, dst.Stride, f.Plane(0), f.Linesize(0), f.Width(), f.Height())
+		img = dst
+	case pixFmtRgba:
+		dst := image.NewRGBA(rect)
+		copyPlane(dst.Pix, dst.Stride, f.Plane(0), f.Linesize(0), 4*f.Width(), f.Height())
+		img = dst
+	default:
+		ratio, ok := pixFmtToSubsampleRatio(pixFmt)
+		if !ok {
+			err = fmt.Errorf("Frame ToImage: unsupported pixel format(%v)", pixFmt)
+			return
+		}
+		// image.YCbCr is full range, copying limited range samples wash out the picture
+		if !isFullRangeYuv(pixFmt) && f.cFrame().color_range != colorRangeJPEG {
+			err = fmt.Errorf("Frame ToImage: pixel format(%v) is not full range, convert to yuvj first", pixFmt)
+			return
+		}
+		dst := image.NewYCbCr(rect, ratio)
+		copyPlane(dst.Y, dst.YStride, f.Plane(0), f.Linesize(0), f.Width(), f.Height())
+		cw, ch := chromaSize(ratio, f.Width(), f.Height())
+		copyPlane(dst.Cb, dst.CStride, f.Plane(1), f.Linesize(1), cw, ch)
+		copyPlane(dst.Cr, dst.CStride, f.Plane(2), f.Linesize(2), cw, ch)
+		img = dst
+	}
+	return
+}
+
+// FromImage create a frame holding a copy of img: yuvj for *image.YCbCr (yuv tagged
+// AVCOL_RANGE_JPEG for 4:1:1 and 4:1:0 which have no yuvj format), gray8 for *image.Gray,
+// rgba for others. Must call f.Free() after use
+func FromImage(img image.Image) (f *Frame, err error) {
+	rect := img.Bounds()
+	w, h := rect.Dx(), rect.Dy()
+
+	switch src := img.(type) {
+	case *image.YCbCr:
+		pixFmt, ok := subsampleRatioToPixFmt(src.SubsampleRatio)
+		if !ok {
+			err = fmt.Errorf("FromImage: unsupported subsample ratio(%v)", src.SubsampleRatio)
+			return
+		}
+		if f, err = NewVideoFrame(w, h, pixFmt); err != nil {
+			return
+		}
+		f.cFrame().color_range = colorRangeJPEG
+		cOff := src.COffset(rect.Min.X, rect.Min.Y)
+		copyPlane(f.Plane(0), f.Linesize(0), src.Y[src.YOffset(rect.Min.X, rect.Min.Y):], src.YStride, w, h)
+		cw, ch := chromaSize(src.SubsampleRatio, w, h)
+		copyPlane(f.Plane(1), f.Linesize(1), src.Cb[cOff:], src.CStride, cw, ch)
+		copyPlane(f.Plane(2), f.Linesize(2), src.Cr[cOff:], src.CStride, cw, ch)
+	case *image.Gray:
+		if f, err = NewVideoFrame(w, h, pixFmtGray8); err != nil {
+			return
+		}
+		copyPlane(f.Plane(0), f.Linesize(0), src.Pix[src.PixOffset(rect.Min.X, rect.Min.Y):], src.Stride, w, h)
+	default:
+		rgba, ok := img.(*image.RGBA)
+		if !ok {
+			rgba = image.NewRGBA(image.Rect(0, 0, w, h))
+			draw.Draw(rgba, rgba.Bounds(), img, rect.Min, draw.Src)
+			rect = rgba.Bounds()
+		}
+		if f, err = NewVideoFrame(w, h, pixFmtRgba); err != nil {
+			return
+		}
+		copyPlane(f.Plane(0), f.Linesize(0), rgba.Pix[rgba.PixOffset(rect.Min.X, rect.Min.Y):], rgba.Stride, 4*w, h)
+	}
+	return
+}
+
+// ChannelSamples Return samples of channel ch converted to float32 in [-1, 1)
+func (f *Frame) ChannelSamples(ch int) (samples []float32, err error) {
+	if f.IsVideo() || ch < 0 || ch >= f.Channels() {
+		err = fmt.Errorf("Frame ChannelSamples: invalid channel(%v)", ch)
+		return
+	}
+	sampleFmt := C.enum_AVSampleFormat(f.SampleFormat())
+	bps := int(C.av_get_bytes_per_sample(sampleFmt))
+	if bps == 0 {
+		err = fmt.Errorf("Frame ChannelSamples: invalid sample format(%v)", f.SampleFormat())
+		return
+	}
+
+	var (
+		data   []byte
+		stride int
+	)
+	if C.av_sample_fmt_is_planar(sampleFmt) != 0 {
+		data, stride = f.Plane(ch), bps
+	} else {
+		data, stride = f.Plane(0)[ch*bps:], bps*f.Channels()
+	}
+
+	samples = make([]float32, f.NbSamples())
+	packedFmt := C.av_get_packed_sample_fmt(sampleFmt)
+	for i := range samples {
+		p := unsafe.Pointer(&data[i*stride])
+		switch packedFmt {
+		case C.AV_SAMPLE_FMT_U8:
+			samples[i] = (float32(*(*uint8)(p)) - 128) / 128
+		case C.AV_SAMPLE_FMT_S16:
+			samples[i] = float32(*(*int16)(p)) / (1 << 15)
+		case C.AV_SAMPLE_FMT_S32:
+			samples[i] = float32(float64(*(*int32)(p)) / (1 << 31))
+		case C.AV_SAMPLE_FMT_S64:
+			samples[i] = float32(float64(*(*int64)(p)) / (1 << 63))
+		case C.AV_SAMPLE_FMT_FLT:
+			samples[i] = *(*float32)(p)
+		case C.AV_SAMPLE_FMT_DBL:
+			samples[i] = float32(*(*float64)(p))
+		}
+	}
+	return
+}
+
+// copyPlane copy rows of width bytes between buffers of different strides
+func copyPlane(dst []byte, dstStride int, src []byte, srcStride int, width, height int) {
+	for y := 0; y < height; y++ {
+		copy(dst[y*dstStride:y*dstStride+width], src[y*srcStride:y*srcStride+width])
+	}
+}
+
+func pixFmtToSubsampleRatio(pixFmt libavcodec.AvPixelFormat) (image.YCbCrSubsampleRatio, bool) {
+	switch pixFmt {
+	case pixFmtYuv420p, pixFmtYuvj420p:
+		return image.YCbCrSubsampleRatio420, true
+	case pixFmtYuv422p, pixFmtYuvj422p:
+		return image.YCbCrSubsampleRatio422, true
+	case pixFmtYuv444p, pixFmtYuvj444p:
+		return image.YCbCrSubsampleRatio444, true
+	case pixFmtYuv440p, pixFmtYuvj440p:
+		return image.YCbCrSubsampleRatio440, true
+	case pixFmtYuv411p:
+		return image.YCbCrSubsampleRatio411, true
+	case pixFmtYuv410p:
+		return image.YCbCrSubsampleRatio410, true
+	}
+	return 0, false
+}
+
+// subsampleRatioToPixFmt return full range yuvj formats, many encoders ignore color_range
+func subsampleRatioToPixFmt(ratio image.YCbCrSubsampleRatio) (libavcodec.AvPixelFormat, bool) {
+	switch ratio {
+	case image.YCbCrSubsampleRatio420:
+		return pixFmtYuvj420p, true
+	case image.YCbCrSubsampleRatio422:
+		return pixFmtYuvj422p, true
+	case image.YCbCrSubsampleRatio444:
+		return pixFmtYuvj444p, true
+	case image.YCbCrSubsampleRatio440:
+		return pixFmtYuvj440p, true
+	case image.YCbCrSubsampleRatio411:
+		return pixFmtYuv411p, true
+	case image.YCbCrSubsampleRatio410:
+		return pixFmtYuv410p, true
+	}
+	return 0, false
+}
+
+// isFullRangeYuv report whether pixFmt is a yuvj format, which is full range by definition
+func isFullRangeYuv(pixFmt libavcodec.AvPixelFormat) bool {
+	switch pixFmt {
+	case pixFmtYuvj420p, pixFmtYuvj422p, pixFmtYuvj444p, pixFmtYuvj440p:
+		return true
+	}
+	return false
+}
+
+// chromaSize Return size of chroma planes of a w x h picture
+func chromaSize(ratio image.YCbCrSubsampleRatio, w, h int) (cw, ch int) {
+	switch ratio {
+	case image.YCbCrSubsampleRatio420:
+		return (w + 1) / 2, (h + 1) / 2
+	case image.YCbCrSubsampleRatio422:
+		return (w + 1) / 2, h
+	case image.YCbCrSubsampleRatio440:
+		return w, (h + 1) / 2
+	case image.YCbCrSubsampleRatio411:
+		return (w + 3) / 4, h
+	case image.YCbCrSubsampleRatio410:
+		return (w + 3) / 4, (h + 1) / 2
+	}
+	return w, h
+}
@@ -0,0 +1,41 @@
+package media
+
+import (
+	"testing"
+)
+
+func TestPacketLeak(t *testing.T) {
+	start := LivePackets()
+
+	pkt := NewPacket()
+	if pkt == nil {
+		t.Fatal("NewPacket return nil")
+	}
+	if err := pkt.SetData([]byte("payload")); err != nil {
+		t.Fatal(err)
+	}
+	c, err := pkt.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(c.Data()) != "payload" {
+		t.Errorf("clone data(%q)", c.Data())
+	}
+	r := NewPacket()
+	if err = r.Ref(pkt); err != nil {
+		t.Fatal(err)
+	}
+	r.Unref()
+	if got := LivePackets() - start; got != 3 {
+		t.Errorf("live packets(%v), want 3", got)
+	}
+
+	pkt.Free()
+	c.Free()
+	r.Free()
+	// freeing again is a no-op
+	pkt.Free()
+	if got := LivePackets(); got != start {
+		t.Errorf("live packets(%v) after free, want %v", got, start)
+	}
+}
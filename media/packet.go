@@ -0,0 +1,266 @@
+// Package media wrap libav* packets and frames with clear ownership
+package media
+
+//#cgo pkg-config: libavcodec libavutil
+//#include <string.h>
+//#include <libavcodec/avcodec.h>
+import "C"
+import (
+	"math"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/xueqing/ffmpeg-demo/averror"
+	"github.com/xueqing/ffmpeg-demo/util"
+	"github.com/xueqing/goav/libavcodec"
+)
+
+// NoPts is returned as time when the timestamp is undefined (AV_NOPTS_VALUE)
+const NoPts = time.Duration(math.MinInt64)
+
+// livePackets count packets allocated and not freed
+var livePackets int64
+
+// LivePackets Return the number of packets not freed yet, can be used to detect leak
+func LivePackets() int64 {
+	return atomic.LoadInt64(&livePackets)
+}
+
+// Packet own an AVPacket and the time base of its timestamps.
+// A Packet must be freed by Free once; Unref only drops the data reference
+// so that the Packet can be reused
+type Packet struct {
+	pPkt     *libavcodec.AvPacket
+	timeBase libavcodec.AvRational
+}
+
+// NewPacket allocate an empty packet
+func NewPacket() *Packet {
+	pPkt := libavcodec.AvPacketAlloc()
+	if pPkt == nil {
+		return nil
+	}
+	atomic.AddInt64(&livePackets, 1)
+	return &Packet{pPkt: pPkt}
+}
+
+// Free unreference the data and free the packet
+func (p *Packet) Free() {
+	if p.pPkt != nil {
+		util.AvPacketFree(p.pPkt)
+		p.pPkt = nil
+		atomic.AddInt64(&livePackets, -1)
+	}
+}
+
+// AvPacket Return the underlying AVPacket, which is still owned by p
+func (p *Packet) AvPacket() *libavcodec.AvPacket {
+	return p.pPkt
+}
+
+// Ref make p reference the data and copy properties of src, p is unreferenced first
+func (p *Packet) Ref(src *Packet) (err error) {
+	p.pPkt.AvPacketUnref()
+	if ret := p.pPkt.AvPacketRef(src.pPkt); ret < 0 {
+		err = averror.New("Packet Ref", ret)
+		return
+	}
+	p.timeBase = src.timeBase
+	return
+}
+
+// Unref drop the data reference and reset properties, p can be reused
+func (p *Packet) Unref() {
+	p.pPkt.AvPacketUnref()
+}
+
+// Clone create a new Packet referencing the same data as p, must be freed by Free
+func (p *Packet) Clone() (c *Packet, err error) {
+	if c = NewPacket(); c == nil {
+		err = averror.New("Packet Clone: alloc packet", averror.CodeNoMem)
+		return
+	}
+	if err = c.Ref(p); err != nil {
+		c.Free()
+		c = nil
+	}
+	return
+}
+
+// Data Return the payload. It shares memory with the packet and is valid until Unref/Free
+func (p *Packet) Data() []byte {
+	return cBytes(unsafe.Pointer(p.pPkt.Data()), p.pPkt.Size())
+}
+
+// SetData replace the payload with a copy of data, p is unreferenced first
+func (p *Packet) SetData(data []byte) (err error) {
+	p.pPkt.AvPacketUnref()
+	if ret := p.pPkt.AvNewPacket(len(data)); ret < 0 {
+		err = averror.New("Packet SetData: alloc payload", ret)
+		return
+	}
+	if len(data) > 0 {
+		C.memcpy(unsafe.Pointer(p.pPkt.Data()), unsafe.Pointer(&data[0]), C.size_t(len(data)))
+	}
+	return
+}
+
+// Size Return size of the payload
+func (p *Packet) Size() int {
+	return p.pPkt.Size()
+}
+
+// StreamIndex Return stream index
+func (p *Packet) StreamIndex() int {
+	return p.pPkt.StreamIndex()
+}
+
+// SetStreamIndex Set stream index
+func (p *Packet) SetStreamIndex(idx int) {
+	p.pPkt.SetStreamIndex(idx)
+}
+
+// TimeBase Return the time base of timestamps, 0/0 if unknown
+func (p *Packet) TimeBase() libavcodec.AvRational {
+	return p.timeBase
+}
+
+// SetTimeBase Set the time base of timestamps without changing them
+func (p *Packet) SetTimeBase(tb libavcodec.AvRational) {
+	p.timeBase = tb
+}
+
+// RescaleTs convert timestamps to time base tb
+func (p *Packet) RescaleTs(tb libavcodec.AvRational) {
+	if p.timeBase.Den() != 0 {
+		p.pPkt.AvPacketRescaleTs(p.timeBase, tb)
+	}
+	p.timeBase = tb
+}
+
+// RawPts Return pts in time base, util.AvNoPtsValue if undefined
+func (p *Packet) RawPts() int64 {
+	return p.pPkt.Pts()
+}
+
+// SetRawPts Set pts in time base
+func (p *Packet) SetRawPts(pts int64) {
+	p.pPkt.SetPts(pts)
+}
+
+// RawDts Return dts in time base, util.AvNoPtsValue if undefined
+func (p *Packet) RawDts() int64 {
+	return p.pPkt.Dts()
+}
+
+// SetRawDts Set dts in time base
+func (p *Packet) SetRawDts(dts int64) {
+	p.pPkt.SetDts(dts)
+}
+
+// RawDuration Return duration in time base, 0 if unknown
+func (p *Packet) RawDuration() int64 {
+	return int64(p.pPkt.Duration())
+}
+
+// SetRawDuration Set duration in time base
+func (p *Packet) SetRawDuration(duration int64) {
+	p.pPkt.SetDuration(duration)
+}
+
+// Pts Return pts as time, NoPts if undefined or time base is unknown
+func (p *Packet) Pts() time.Duration {
+	return p.toTime(p.RawPts())
+}
+
+// Dts Return dts as time, NoPts if undefined or time base is unknown
+func (p *Packet) Dts() time.Duration {
+	return p.toTime(p.RawDts())
+}
+
+// Duration Return duration as time, 0 if time base is unknown
+func (p *Packet) Duration() time.Duration {
+	if p.timeBase.Den() == 0 {
+		return 0
+	}
+	return util.TsToDuration(p.RawDuration(), p.timeBase)
+}
+
+// Pos Return byte position in stream, -1 if unknown
+func (p *Packet) Pos() int64 {
+	return p.pPkt.Pos()
+}
+
+// IsKeyframe Return whether the packet contains a keyframe
+func (p *Packet) IsKeyframe() bool {
+	return p.pPkt.Flags()&libavcodec.AvPktFlagKey != 0
+}
+
+// SetKeyframe Set or clear keyframe flag
+func (p *Packet) SetKeyframe(key bool) {
+	p.setFlag(libavcodec.AvPktFlagKey, key)
+}
+
+// IsCorrupt Return whether the packet content is corrupted
+func (p *Packet) IsCorrupt() bool {
+	return p.pPkt.Flags()&libavcodec.AvPktFlagCorrupt != 0
+}
+
+// SideData Return side data of typ, nil if absent. It shares memory with the packet
+func (p *Packet) SideData(typ libavcodec.AvPacketSideDataType) []byte {
+	var size C.int
+	data := C.av_packet_get_side_data((*C.AVPacket)(unsafe.Pointer(p.pPkt)),
+		C.enum_AVPacketSideDataType(typ), &size)
+	return cBytes(unsafe.Pointer(data), int(size))
+}
+
+// AddSideData add a copy of data as side data of typ
+func (p *Packet) AddSideData(typ libavcodec.AvPacketSideDataType, data []byte) (err error) {
+	dst := C.av_packet_new_side_data((*C.AVPacket)(unsafe.Pointer(p.pPkt)),
+		C.enum_AVPacketSideDataType(typ), C.int(len(data)))
+	if dst == nil {
+		err = averror.New("Packet AddSideData: alloc side data", averror.CodeNoMem)
+		return
+	}
+	if len(data) > 0 {
+		C.memcpy(unsafe.Pointer(dst), unsafe.Pointer(&data[0]), C.size_t(len(data)))
+	}
+	return
+}
+
+// SideDataTypes Return types of all side data
+func (p *Packet) SideDataTypes() (types []libavcodec.AvPacketSideDataType) {
+	pPkt := (*C.AVPacket)(unsafe.Pointer(p.pPkt))
+	nb := int(pPkt.side_data_elems)
+	if nb == 0 {
+		return
+	}
+	for _, sd := range (*[1 << 20]C.AVPacketSideData)(unsafe.Pointer(pPkt.side_data))[:nb:nb] {
+		types = append(types, libavcodec.AvPacketSideDataType(sd._type))
+	}
+	return
+}
+
+func (p *Packet) toTime(ts int64) time.Duration {
+	if ts == util.AvNoPtsValue || p.timeBase.Den() == 0 {
+		return NoPts
+	}
+	return util.TsToDuration(ts, p.timeBase)
+}
+
+func (p *Packet) setFlag(flag int, on bool) {
+	if on {
+		p.pPkt.SetFlags(p.pPkt.Flags() | flag)
+	} else {
+		p.pPkt.SetFlags(p.pPkt.Flags() &^ flag)
+	}
+}
+
+// cBytes Return a slice sharing C memory
+func cBytes(data unsafe.Pointer, size int) []byte {
+	if data == nil || size <= 0 {
+		return nil
+	}
+	return (*[1 << 30]byte)(data)[:size:size]
+}
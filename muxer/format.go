@@ -0,0 +1,16 @@
+package muxer
+
+//#cgo pkg-config: libavformat
+//#include <libavformat/avformat.h>
+import "C"
+import (
+	"unsafe"
+
+	"github.com/xueqing/goav/libavformat"
+)
+
+// outputFormatFlags Return AVOutputFormat.flags (AVFMT_xxx) of pOutFmtCtx
+func outputFormatFlags(pOutFmtCtx *libavformat.AvFormatContext) int {
+	pCtx := (*C.AVFormatContext)(unsafe.Pointer(pOutFmtCtx))
+	return int(pCtx.oformat.flags)
+}
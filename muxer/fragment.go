@@ -0,0 +1,233 @@
+package muxer
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/xueqing/ffmpeg-demo/logutil"
+	"github.com/xueqing/ffmpeg-demo/media"
+	"github.com/xueqing/ffmpeg-demo/util"
+	"github.com/xueqing/goav/libavcodec"
+	"github.com/xueqing/goav/libavutil"
+)
+
+// FragmentMode decide where fragments are cut
+type FragmentMode int
+
+// Modes of fragmenting
+const (
+	// FragmentKeyframe cut before keyframes of the reference stream,
+	// fragments are at least FragmentConfig.Duration long
+	FragmentKeyframe FragmentMode = iota
+	// FragmentDuration cut every FragmentConfig.Duration of the reference stream regardless
+	// of keyframes, e.g. CMAF chunks for low latency delivery
+	FragmentDuration
+)
+
+// FragmentConfig configure fragmented MP4 output, see OpenFragmented
+type FragmentConfig struct {
+	Mode FragmentMode
+	// Duration is the minimum duration of fragments of FragmentKeyframe mode,
+	// or the duration of fragments of FragmentDuration mode
+	Duration time.Duration
+	// RefStream is index of the output stream deciding fragment boundaries,
+	// nil select the first video stream, or the first stream if there is no video
+	RefStream *int
+	// CMAF write CMAF compliant fragments (movflags cmaf), requires FFmpeg 4.3 or later
+	CMAF bool
+
+	// InitHandler receive the init segment (ftyp and moov) after WriteHeader
+	InitHandler func(data []byte) error
+	// FragmentHandler receive each media fragment (moof and mdat).
+	// data is only valid until handler returns
+	FragmentHandler func(frag Fragment, data []byte) error
+}
+
+// Fragment describe a media fragment by the reference stream
+type Fragment struct {
+	// Sequence number starting at 1, same as in mfhd box
+	Sequence int
+	// StreamIndex is index of the reference stream
+	StreamIndex int
+	// DecodeTs is dts of the first packet of the reference stream in TimeBase,
+	// DecodeTime is it in time
+	DecodeTs   int64
+	TimeBase   libavcodec.AvRational
+	DecodeTime time.Duration
+	Duration   time.Duration
+	// Keyframe report whether the fragment starts with a keyframe of the reference stream
+	Keyframe bool
+	// Packets is number of packets of all streams
+	Packets int
+}
+
+// fragmenter cut packets written to a frag_custom mp4 muxer into fragments
+type fragmenter struct {
+	cfg FragmentConfig
+	// buf collect bytes written by the muxer until they are passed to handlers
+	buf bytes.Buffer
+	ref int
+	tb  libavcodec.AvRational
+
+	seq     int
+	packets int
+	// started report whether the current fragment has a packet of the reference stream
+	started  bool
+	startDts int64
+	keyframe bool
+	// end of the last packet of the reference stream
+	endDts int64
+}
+
+// OpenFragmented initialize format context writing fragmented MP4 (fMP4/CMAF). The init segment
+// and fragments are passed to handlers of cfg instead of a file. Packets passed to Write must
+// be interleaved by caller since they are written without interleaving to keep fragment
+// boundaries. The index written by WriteTrailer (mfra) is discarded
+func (m *Muxer) OpenFragmented(cfg FragmentConfig) (err error) {
+	if cfg.InitHandler == nil || cfg.FragmentHandler == nil {
+		err = fmt.Errorf("Muxer OpenFragmented: init and fragment handlers are required")
+		return
+	}
+	if cfg.Mode == FragmentDuration && cfg.Duration <= 0 {
+		err = fmt.Errorf("Muxer OpenFragmented: invalid fragment duration(%v)", cfg.Duration)
+		return
+	}
+	f := &fragmenter{cfg: cfg, seq: 1}
+	if err = m.OpenWriter(&f.buf, "mp4"); err != nil {
+		return
+	}
+	m.frag = f
+	return
+}
+
+// options add movflags of fragmented output to options of WriteHeader
+func (f *fragmenter) options(options map[string]interface{}) (opts map[string]interface{}, err error) {
+	opts = make(map[string]interface{}, len(options)+1)
+	for k, v := range options {
+		opts[k] = v
+	}
+	var movflags string
+	if v, ok := opts["movflags"]; ok {
+		if movflags, err = util.OptionValue(v); err != nil {
+			err = fmt.Errorf("Muxer WriteHeader: key(movflags) %v", err)
+			return
+		}
+	}
+	// fragments are flushed by av_write_frame(NULL) only
+	flags := util.Flags{"empty_moov", "default_base_moof", "frag_custom"}
+	if f.cfg.CMAF {
+		flags = append(flags, "cmaf")
+	}
+	opts["movflags"] = movflags + flags.String()
+	return
+}
+
+// init select the reference stream and pass the init segment to InitHandler
+func (f *fragmenter) init(m *Muxer) (err error) {
+	streams := m.pOutFmtCtx.Streams()
+	f.ref = 0
+	if f.cfg.RefStream != nil {
+		if f.ref = *f.cfg.RefStream; f.ref < 0 || f.ref >= len(streams) {
+			err = fmt.Errorf("Muxer WriteHeader: invalid fragment reference stream(%v)", f.ref)
+			return
+		}
+	} else {
+		for idx, st := range streams {
+			if libavutil.AvMediaType(st.CodecParameters().CodecType()) == libavutil.AvmediaTypeVideo {
+				f.ref = idx
+				break
+			}
+		}
+	}
+	// time base is final after header is written
+	f.tb = streams[f.ref].TimeBase()
+
+	m.ioCtx.Flush()
+	err = f.cfg.InitHandler(f.buf.Bytes())
+	f.buf.Reset()
+	return
+}
+
+// before cut the current fragment if pkt of the reference stream starts a new one
+func (f *fragmenter) before(m *Muxer, pkt *media.Packet) (err error) {
+	if pkt.StreamIndex() != f.ref {
+		return
+	}
+	dts := pkt.RawDts()
+	if f.started {
+		elapsed := util.TsToDuration(dts-f.startDts, f.tb)
+		cut := elapsed >= f.cfg.Duration
+		if f.cfg.Mode == FragmentKeyframe {
+			cut = cut && pkt.IsKeyframe()
+		}
+		if !cut {
+			return
+		}
+		if err = f.cut(m, dts); err != nil {
+			return
+		}
+	}
+	if !f.started {
+		f.started = true
+		f.startDts = dts
+		f.keyframe = pkt.IsKeyframe()
+	}
+	return
+}
+
+// after count a packet of stream idx written to the current fragment
+func (f *fragmenter) after(idx int, dts, duration int64) {
+	f.packets++
+	if idx == f.ref {
+		f.endDts = dts + duration
+	}
+}
+
+// cut flush the current fragment ending at endDts of the reference stream to FragmentHandler
+func (f *fragmenter) cut(m *Muxer, endDts int64) (err error) {
+	if f.packets == 0 {
+		return
+	}
+	if ret := m.pOutFmtCtx.AvWriteFrame(nil); ret < 0 {
+		err = m.wrapError("Muxer Write: flush fragment", ret)
+		return
+	}
+	m.ioCtx.Flush()
+
+	frag := Fragment{
+		Sequence:    f.seq,
+		StreamIndex: f.ref,
+		DecodeTs:    f.startDts,
+		TimeBase:    f.tb,
+		DecodeTime:  util.TsToDuration(f.startDts, f.tb),
+		Duration:    util.TsToDuration(endDts-f.startDts, f.tb),
+		Keyframe:    f.keyframe,
+		Packets:     f.packets,
+	}
+	err = f.cfg.FragmentHandler(frag, f.buf.Bytes())
+	m.log().Debug("Muxer Write: fragment", logutil.F("sequence", frag.Sequence),
+		logutil.F("decode_time", frag.DecodeTime), logutil.F("duration", frag.Duration),
+		logutil.F("size", f.buf.Len()))
+	f.buf.Reset()
+	f.seq++
+	f.packets = 0
+	f.started = false
+	return
+}
+
+// writeFragmented write pkt without interleaving, cutting fragments before it if needed
+func (m *Muxer) writeFragmented(pkt *media.Packet) (err error) {
+	if err = m.frag.before(m, pkt); err != nil {
+		return
+	}
+	idx, dts, duration := pkt.StreamIndex(), pkt.RawDts(), pkt.RawDuration()
+	ret := m.pOutFmtCtx.AvWriteFrame(pkt.AvPacket())
+	pkt.Unref()
+	if ret < 0 {
+		err = m.wrapError("Muxer Write", ret)
+		return
+	}
+	m.frag.after(idx, dts, duration)
+	return
+}
@@ -0,0 +1,122 @@
+package muxer
+
+//#cgo pkg-config: libavformat libavutil
+//#include <stdlib.h>
+//#include <libavformat/avformat.h>
+//#include <libavutil/dict.h>
+//#include <libavutil/mem.h>
+//
+//// add_chapter append a chapter of start and end in microseconds, return its id
+//static int add_chapter(AVFormatContext *s, int64_t start, int64_t end, const char *title)
+//{
+//    AVChapter *ch = av_mallocz(sizeof(*ch));
+//    int ret;
+//
+//    if (!ch)
+//        return AVERROR(ENOMEM);
+//    ch->id        = s->nb_chapters;
+//    ch->time_base = (AVRational){ 1, AV_TIME_BASE };
+//    ch->start     = start;
+//    ch->end       = end;
+//    if (title && (ret = av_dict_set(&ch->metadata, "title", title, 0)) < 0)
+//        goto fail;
+//    if ((ret = av_dynarray_add_nofree(&s->chapters, (int *)&s->nb_chapters, ch)) < 0)
+//        goto fail;
+//    return ch->id;
+//
+//fail:
+//    av_dict_free(&ch->metadata);
+//    av_free(ch);
+//    return ret;
+//}
+import "C"
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"github.com/xueqing/ffmpeg-demo/averror"
+)
+
+// CreationTimeFormat is layout of "creation_time" metadata, e.g. 2021-03-04T05:06:07.000000Z
+const CreationTimeFormat = "2006-01-02T15:04:05.000000Z"
+
+// FormatCreationTime return t as value of "creation_time" metadata
+func FormatCreationTime(t time.Time) string {
+	return t.UTC().Format(CreationTimeFormat)
+}
+
+// SetMetadata set a container metadata tag, e.g. title, encoder, creation_time.
+// An empty value delete the tag. Must be called before WriteHeader
+func (m *Muxer) SetMetadata(key, value string) (err error) {
+	if err = m.checkBeforeHeader("Muxer SetMetadata"); err != nil {
+		return
+	}
+	pCtx := (*C.AVFormatContext)(unsafe.Pointer(m.pOutFmtCtx))
+	return setTag(&pCtx.metadata, "Muxer SetMetadata", key, value)
+}
+
+// SetStreamMetadata set a metadata tag of output stream idx, e.g. language, title, handler_name.
+// An empty value delete the tag. Must be called before WriteHeader
+func (m *Muxer) SetStreamMetadata(idx int, key, value string) (err error) {
+	if err = m.checkBeforeHeader("Muxer SetStreamMetadata"); err != nil {
+		return
+	}
+	if idx < 0 || idx >= int(m.pOutFmtCtx.NbStreams()) {
+		err = fmt.Errorf("Muxer SetStreamMetadata: invalid stream index(%v)", idx)
+		return
+	}
+	pStream := (*C.AVStream)(unsafe.Pointer(m.pOutFmtCtx.Streams()[idx]))
+	return setTag(&pStream.metadata, "Muxer SetStreamMetadata", key, value)
+}
+
+// AddChapter add a chapter from start to end with title, chapters should be added in order.
+// Formats without chapter support ignore them. Must be called before WriteHeader.
+// Return id of the chapter
+func (m *Muxer) AddChapter(start, end time.Duration, title string) (id int, err error) {
+	if err = m.checkBeforeHeader("Muxer AddChapter"); err != nil {
+		return
+	}
+	if start < 0 || end < start {
+		err = fmt.Errorf("Muxer AddChapter: invalid chapter from %v to %v", start, end)
+		return
+	}
+	var cTitle *C.char
+	if title != "" {
+		cTitle = C.CString(title)
+		defer C.free(unsafe.Pointer(cTitle))
+	}
+	ret := int(C.add_chapter((*C.AVFormatContext)(unsafe.Pointer(m.pOutFmtCtx)),
+		C.int64_t(start.Microseconds()), C.int64_t(end.Microseconds()), cTitle))
+	if ret < 0 {
+		err = averror.New("Muxer AddChapter", ret)
+		return
+	}
+	id = ret
+	return
+}
+
+// checkBeforeHeader return error if the output is not opened or the header is written
+func (m *Muxer) checkBeforeHeader(op string) error {
+	if m.pOutFmtCtx == nil {
+		return fmt.Errorf("%v: output format context is nil", op)
+	}
+	if m.headerWritten {
+		return fmt.Errorf("%v: header is written", op)
+	}
+	return nil
+}
+
+func setTag(pDict **C.AVDictionary, op, key, value string) error {
+	cKey := C.CString(key)
+	defer C.free(unsafe.Pointer(cKey))
+	var cValue *C.char
+	if value != "" {
+		cValue = C.CString(value)
+		defer C.free(unsafe.Pointer(cValue))
+	}
+	if ret := int(C.av_dict_set(pDict, cKey, cValue, 0)); ret < 0 {
+		return averror.New(fmt.Sprintf("%v: set key(%v)", op, key), ret)
+	}
+	return nil
+}
@@ -2,10 +2,13 @@ package muxer
 
 import (
 	"fmt"
+	"io"
 	"unsafe"
 
-	"github.com/google/logger"
-
+	"github.com/xueqing/ffmpeg-demo/averror"
+	"github.com/xueqing/ffmpeg-demo/avio"
+	"github.com/xueqing/ffmpeg-demo/logutil"
+	"github.com/xueqing/ffmpeg-demo/media"
 	"github.com/xueqing/ffmpeg-demo/util"
 	"github.com/xueqing/goav/libavcodec"
 	"github.com/xueqing/goav/libavformat"
@@ -14,7 +17,19 @@ import (
 
 // Muxer mux packets
 type Muxer struct {
+	// structured logger, logutil.Default() is used when nil
+	Logger *logutil.Logger
+	// TsPolicy handle packets of missing or non-monotonic timestamps passed to Write
+	TsPolicy TsPolicy
+
 	pOutFmtCtx *libavformat.AvFormatContext
+	ioCtx      *avio.Context
+	// timestamps by output stream index
+	ts []streamTs
+	// metadata and chapters can not be changed after header is written
+	headerWritten bool
+	// frag is set by OpenFragmented
+	frag *fragmenter
 }
 
 // New init a muxer
@@ -28,16 +43,32 @@ func (m *Muxer) OutFormatContext() *libavformat.AvFormatContext {
 	return m.pOutFmtCtx
 }
 
+// log return Logger, or logutil.Default() if it is not set
+func (m *Muxer) log() *logutil.Logger {
+	if m.Logger != nil {
+		return m.Logger
+	}
+	return logutil.Default()
+}
+
 // Close release memory
 func (m *Muxer) Close() {
 	if m.pOutFmtCtx != nil {
-		if (m.pOutFmtCtx.Flags() & libavformat.AvfmtNofile) == 0 {
+		// Custom AVIOContext is freed below instead of avio_close
+		if m.ioCtx == nil && (outputFormatFlags(m.pOutFmtCtx)&libavformat.AvfmtNofile) == 0 {
 			libavformat.AvioClosep(m.pOutFmtCtx.Pb())
 		}
 		// Free an AVFormatContext and all its streams.
 		m.pOutFmtCtx.AvformatFreeContext()
 		m.pOutFmtCtx = nil
 	}
+	if m.ioCtx != nil {
+		m.ioCtx.Close()
+		m.ioCtx = nil
+	}
+	m.ts = nil
+	m.headerWritten = false
+	m.frag = nil
 }
 
 // Open initialize format context
@@ -51,14 +82,15 @@ func (m *Muxer) Open(strURL, strFmt string) (err error) {
 	// free the context and everything allocated by the framework within it.
 	var pOutFmt *libavformat.AvOutputFormat
 	if ret := libavformat.AvformatAllocOutputContext2(&m.pOutFmtCtx, pOutFmt, strFmt, strURL); ret < 0 {
-		err = fmt.Errorf("Muxer Open: alloc output context error(%v)", libavutil.ErrorFromCode(ret))
+		err = averror.New("Muxer Open: alloc output context", ret)
 		return
 	}
 
 	// Create and initialize a AVIOContext for accessing the resource indicated by url.
-	if (m.pOutFmtCtx.Flags() & libavformat.AvfmtNofile) == 0 {
+	if (outputFormatFlags(m.pOutFmtCtx) & libavformat.AvfmtNofile) == 0 {
 		var pIOCtx *libavformat.AvIOContext
 		if pIOCtx, err = libavformat.AvIOOpen(strURL, libavformat.AvioFlagWrite); err != nil {
+			err = fmt.Errorf("Muxer Open: open output(%v) error(%w)", strURL, err)
 			return
 		}
 		m.pOutFmtCtx.SetPb(pIOCtx)
@@ -67,36 +99,100 @@ func (m *Muxer) Open(strURL, strFmt string) (err error) {
 	return
 }
 
-// AddStream save stream
+// OpenWriter initialize format context writing to w instead of a url.
+// strFmt is required since it can not be guessed from a url. Seeking is supported
+// when w implements io.WriteSeeker, which some muxers need (e.g. mp4 rewrites moov atom)
+func (m *Muxer) OpenWriter(w io.Writer, strFmt string) (err error) {
+	if m.pOutFmtCtx != nil {
+		err = fmt.Errorf("Muxer OpenWriter: output format context is not nil")
+		return
+	}
+	if len(strFmt) == 0 {
+		err = fmt.Errorf("Muxer OpenWriter: output format is empty")
+		return
+	}
+
+	var pOutFmt *libavformat.AvOutputFormat
+	if ret := libavformat.AvformatAllocOutputContext2(&m.pOutFmtCtx, pOutFmt, strFmt, ""); ret < 0 {
+		err = averror.New("Muxer OpenWriter: alloc output context", ret)
+		return
+	}
+
+	// Formats with AVFMT_NOFILE (e.g. image2 pattern) do not write through pb.
+	if (outputFormatFlags(m.pOutFmtCtx) & libavformat.AvfmtNofile) != 0 {
+		err = fmt.Errorf("Muxer OpenWriter: output format(%v) does not support custom io", strFmt)
+		m.Close()
+		return
+	}
+	if m.ioCtx, err = avio.NewWriter(w); err != nil {
+		m.Close()
+		return
+	}
+	m.pOutFmtCtx.SetPb(m.ioCtx.AvIOContext())
+	return
+}
+
+// AddStream add a blank stream, pInStream is not used and the caller must set up the stream.
+//
+// Deprecated: use AddStreamCopy or AddStreamFromEncoder
 func (m *Muxer) AddStream(pInStream *libavformat.AvStream) (pOutStream *libavformat.AvStream, err error) {
 	if m.pOutFmtCtx == nil {
 		err = fmt.Errorf("Muxer AddStream: output format context is nil")
 		return
 	}
 	if pOutStream = m.pOutFmtCtx.AvformatNewStream(nil); pOutStream == nil {
-		err = fmt.Errorf("Muxer AddStream: new stream error")
+		err = averror.New("Muxer AddStream: new stream", averror.CodeNoMem)
 		return
 	}
 	return
 }
 
-// WriteHeader save stream header
+// GlobalHeader report whether the output format wants global headers, encoders of
+// its streams should then set AV_CODEC_FLAG_GLOBAL_HEADER
+func (m *Muxer) GlobalHeader() bool {
+	if m.pOutFmtCtx == nil {
+		return false
+	}
+	return (outputFormatFlags(m.pOutFmtCtx) & libavformat.AvfmtGlobalheader) != 0
+}
+
+// WriteHeader save stream header. options are private options of the output format, values
+// are formatted by util.GetAVDictionaryForObject, e.g. {"movflags": util.Flags{"faststart"},
+// "max_interleave_delta": 5 * time.Second, "write_id3v2": true}.
+// Options not found by the output format are logged
 func (m *Muxer) WriteHeader(options map[string]interface{}) (err error) {
 	if m.pOutFmtCtx == nil {
 		err = fmt.Errorf("Muxer WriteHeader: output format context is nil")
 		return
 	}
+	if m.frag != nil {
+		if options, err = m.frag.options(options); err != nil {
+			return
+		}
+	}
 	var pDict *libavutil.AvDictionary
-	if pDict, err = util.GetAVDictionaryFromMap(options); err != nil {
+	if pDict, err = util.GetAVDictionaryForObject(unsafe.Pointer(m.pOutFmtCtx), options); err != nil {
 		return
 	}
-	defer pDict.AvDictFree()
+	// avformat_write_header free the dictionary and replace it with the unused entries
+	defer func() { pDict.AvDictFree() }()
 
 	// Allocate the stream private data and write the stream header to an output media file.
 	if ret := m.pOutFmtCtx.AvformatWriteHeader((**libavutil.AvDictionary)(unsafe.Pointer(&pDict))); ret < 0 {
-		err = fmt.Errorf("Muxer WriteHeader: error(%v)", libavutil.ErrorFromCode(ret))
+		err = m.wrapError("Muxer WriteHeader", ret)
 		return
 	}
+	m.headerWritten = true
+	// Entries left in the dictionary are not found by the output format.
+	if unused := util.GetKeysFromAVDictionary(pDict); len(unused) > 0 {
+		m.log().Warn("Muxer WriteHeader: options not found", logutil.F("options", unused))
+	}
+	if m.frag != nil {
+		if err = m.frag.init(m); err != nil {
+			return
+		}
+	}
+	m.log().Debug("Muxer WriteHeader", logutil.F("streams", m.pOutFmtCtx.NbStreams()))
 
 	return nil
 }
@@ -109,7 +205,7 @@ func (m *Muxer) WritePacket(pPkt *libavcodec.AvPacket) (err error) {
 	}
 	// Write a packet to an output media file.
 	if ret := m.pOutFmtCtx.AvWriteFrame(pPkt); ret < 0 {
-		err = fmt.Errorf("Muxer WritePacket: Write frame error(%v)", libavutil.ErrorFromCode(ret))
+		err = m.wrapError("Muxer WritePacket", ret)
 		return
 	}
 	return
@@ -123,7 +219,41 @@ func (m *Muxer) IntervedWritePacket(pPkt *libavcodec.AvPacket) (err error) {
 	}
 	// Write a packet to an output media file.
 	if ret := m.pOutFmtCtx.AvInterleavedWriteFrame(pPkt); ret < 0 {
-		err = fmt.Errorf("Muxer IntervedWritePacket: Write frame error(%v)", libavutil.ErrorFromCode(ret))
+		err = m.wrapError("Muxer IntervedWritePacket", ret)
+		return
+	}
+	return
+}
+
+// Write mux a packet with interleaving. Timestamps are rescaled from the time base of pkt
+// (e.g. set by Demuxer.Read or Encoder.Receive) to the time base of the output stream, they are
+// assumed in the output time base if pkt has none. Missing or non-monotonic timestamps are
+// handled by TsPolicy, a dropped packet is not an error.
+// The muxer takes the data reference, pkt is unreferenced but still must be freed by caller
+func (m *Muxer) Write(pkt *media.Packet) (err error) {
+	if m.pOutFmtCtx == nil {
+		err = fmt.Errorf("Muxer Write: output format context is nil")
+		return
+	}
+	idx := pkt.StreamIndex()
+	if idx < 0 || idx >= int(m.pOutFmtCtx.NbStreams()) {
+		err = fmt.Errorf("Muxer Write: invalid stream index(%v)", idx)
+		return
+	}
+	pkt.RescaleTs(m.pOutFmtCtx.Streams()[idx].TimeBase())
+	drop, err := m.checkTs(pkt)
+	if err != nil {
+		return
+	}
+	if drop {
+		pkt.Unref()
+		return
+	}
+	if m.frag != nil {
+		return m.writeFragmented(pkt)
+	}
+	if ret := m.pOutFmtCtx.AvInterleavedWriteFrame(pkt.AvPacket()); ret < 0 {
+		err = m.wrapError("Muxer Write", ret)
 		return
 	}
 	return
@@ -132,12 +262,25 @@ func (m *Muxer) IntervedWritePacket(pPkt *libavcodec.AvPacket) (err error) {
 // WriteTrailer write stream trailer
 func (m *Muxer) WriteTrailer() int {
 	if m.pOutFmtCtx == nil {
-		logger.Errorf("Muxer WriteTrailer: output format context is nil")
+		m.log().Error("Muxer WriteTrailer: output format context is nil")
 		return -1
 	}
+	if m.frag != nil {
+		if err := m.frag.cut(m, m.frag.endDts); err != nil {
+			m.log().Error("Muxer WriteTrailer: flush fragment", logutil.F("error", err))
+			if code := averror.Code(err); code < 0 {
+				return code
+			}
+			return averror.CodeExternal
+		}
+	}
 	// Write the stream trailer to an output media file and free the file private data.
 	// May only be called after a successful call to WriteHeader.
-	return m.pOutFmtCtx.AvWriteTrailer()
+	ret := m.pOutFmtCtx.AvWriteTrailer()
+	if m.frag != nil {
+		m.frag.buf.Reset()
+	}
+	return ret
 }
 
 // Streams get streams
@@ -147,3 +290,11 @@ func (m *Muxer) Streams() ([]*libavformat.AvStream, error) {
 	}
 	return m.pOutFmtCtx.Streams(), nil
 }
+
+// wrapError Return AVError of ret, caused by the error of custom writer if any
+func (m *Muxer) wrapError(op string, ret int) error {
+	if m.ioCtx != nil && m.ioCtx.Err() != nil {
+		return averror.Wrap(op, ret, m.ioCtx.Err())
+	}
+	return averror.New(op, ret)
+}
@@ -0,0 +1,106 @@
+package muxer
+
+//#cgo pkg-config: libavformat libavcodec libavutil
+//#include <string.h>
+//#include <libavformat/avformat.h>
+//#include <libavcodec/avcodec.h>
+//#include <libavutil/dict.h>
+//
+//// copy_stream set up out to store packets of in as they are
+//static int copy_stream(const AVFormatContext *oc, AVStream *out, const AVStream *in)
+//{
+//    const AVCodecParameters *par = in->codecpar;
+//    unsigned int tag;
+//    int ret, i;
+//
+//    if ((ret = avcodec_parameters_copy(out->codecpar, par)) < 0)
+//        return ret;
+//    // keep the codec tag unless the output container maps it to another codec
+//    if (oc->oformat->codec_tag &&
+//        av_codec_get_id(oc->oformat->codec_tag, par->codec_tag) != par->codec_id &&
+//        av_codec_get_tag2(oc->oformat->codec_tag, par->codec_id, &tag))
+//        out->codecpar->codec_tag = 0;
+//
+//    out->time_base           = in->time_base;
+//    out->avg_frame_rate      = in->avg_frame_rate;
+//    out->r_frame_rate        = in->r_frame_rate;
+//    out->sample_aspect_ratio = in->sample_aspect_ratio;
+//    out->disposition         = in->disposition;
+//    if ((ret = av_dict_copy(&out->metadata, in->metadata, 0)) < 0)
+//        return ret;
+//    for (i = 0; i < in->nb_side_data; i++) {
+//        const AVPacketSideData *sd = &in->side_data[i];
+//        uint8_t *data = av_stream_new_side_data(out, sd->type, sd->size);
+//        if (!data)
+//            return AVERROR(ENOMEM);
+//        memcpy(data, sd->data, sd->size);
+//    }
+//    return 0;
+//}
+import "C"
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/xueqing/ffmpeg-demo/averror"
+	"github.com/xueqing/ffmpeg-demo/encoder"
+	"github.com/xueqing/goav/libavcodec"
+	"github.com/xueqing/goav/libavformat"
+)
+
+// AddStreamCopy add a output stream storing packets of pInStream without transcoding.
+// Codec parameters, time base, frame rates, disposition, metadata and side data are copied,
+// the codec tag is reset if the output container maps it to another codec.
+// Return error if the output container does not support the codec
+func (m *Muxer) AddStreamCopy(pInStream *libavformat.AvStream) (pOutStream *libavformat.AvStream, err error) {
+	codecID := pInStream.CodecParameters().CodecID()
+	if pOutStream, err = m.newStream("Muxer AddStreamCopy", codecID); err != nil {
+		return
+	}
+	if ret := int(C.copy_stream((*C.AVFormatContext)(unsafe.Pointer(m.pOutFmtCtx)),
+		(*C.AVStream)(unsafe.Pointer(pOutStream)), (*C.AVStream)(unsafe.Pointer(pInStream)))); ret < 0 {
+		err = averror.New(fmt.Sprintf("Muxer AddStreamCopy: copy stream(%v)", pInStream.Index()), ret)
+		return
+	}
+	return
+}
+
+// AddStreamFromEncoder add a output stream storing packets of the opened encoder enc,
+// codec parameters and time base are taken from it. Packets must be rescaled to the
+// time base of the stream, which may be changed by WriteHeader.
+// Return error if the output container does not support the codec
+func (m *Muxer) AddStreamFromEncoder(enc *encoder.Encoder) (pOutStream *libavformat.AvStream, err error) {
+	pEncCtx := enc.EncCodecContext()
+	if pEncCtx == nil {
+		err = fmt.Errorf("Muxer AddStreamFromEncoder: encoder is not opened")
+		return
+	}
+	if pOutStream, err = m.newStream("Muxer AddStreamFromEncoder", libavcodec.AvCodecID(pEncCtx.CodecID())); err != nil {
+		return
+	}
+	if err = enc.CopyCodecParameters(pOutStream.CodecParameters()); err != nil {
+		return
+	}
+	pOutStream.SetTimeBase(enc.TimeBase())
+	return
+}
+
+// newStream add a stream after checking that the output container supports codecID
+func (m *Muxer) newStream(op string, codecID libavcodec.AvCodecID) (pOutStream *libavformat.AvStream, err error) {
+	if m.pOutFmtCtx == nil {
+		err = fmt.Errorf("%v: output format context is nil", op)
+		return
+	}
+	pCtx := (*C.AVFormatContext)(unsafe.Pointer(m.pOutFmtCtx))
+	// negative if the output format does not tell, the codec is then assumed to be supported
+	if C.avformat_query_codec(pCtx.oformat, C.enum_AVCodecID(codecID), C.FF_COMPLIANCE_NORMAL) == 0 {
+		err = averror.New(fmt.Sprintf("%v: codec(%v) is not supported by output format(%v)",
+			op, libavcodec.AvcodecGetName(codecID), C.GoString(pCtx.oformat.name)), averror.CodeInvalidArg)
+		return
+	}
+	if pOutStream = m.pOutFmtCtx.AvformatNewStream(nil); pOutStream == nil {
+		err = averror.New(op+": new stream", averror.CodeNoMem)
+		return
+	}
+	return
+}
@@ -0,0 +1,162 @@
+package muxer
+
+import (
+	"fmt"
+
+	"github.com/xueqing/ffmpeg-demo/averror"
+	"github.com/xueqing/ffmpeg-demo/logutil"
+	"github.com/xueqing/ffmpeg-demo/media"
+	"github.com/xueqing/ffmpeg-demo/util"
+	"github.com/xueqing/goav/libavformat"
+)
+
+// TsPolicy decide how Write handles packets with missing or non-monotonic timestamps
+type TsPolicy int
+
+// Policies of invalid timestamps
+const (
+	// TsFix fill missing timestamps, raise dts to keep it monotonic and pts to be not less than dts
+	TsFix TsPolicy = iota
+	// TsDrop discard the packet
+	TsDrop
+	// TsError return error, the packet is not written
+	TsError
+)
+
+// String return lower case policy name
+func (p TsPolicy) String() string {
+	switch p {
+	case TsFix:
+		return "fix"
+	case TsDrop:
+		return "drop"
+	case TsError:
+		return "error"
+	}
+	return fmt.Sprintf("policy(%d)", int(p))
+}
+
+// TsStats count packets with invalid timestamps, a packet may be counted by several problems
+type TsStats struct {
+	// Missing count packets without pts or dts
+	Missing int64
+	// NonMonotonic count packets with dts not increasing
+	NonMonotonic int64
+	// Invalid count packets with pts less than dts
+	Invalid int64
+	// Fixed and Dropped count packets handled by TsPolicy
+	Fixed   int64
+	Dropped int64
+}
+
+func (s *TsStats) add(o TsStats) {
+	s.Missing += o.Missing
+	s.NonMonotonic += o.NonMonotonic
+	s.Invalid += o.Invalid
+	s.Fixed += o.Fixed
+	s.Dropped += o.Dropped
+}
+
+// streamTs track timestamps written to a output stream
+type streamTs struct {
+	lastDts int64
+	stats   TsStats
+}
+
+// TsStats return counts of packets with invalid timestamps of output stream idx,
+// or the sum of all streams if idx is negative
+func (m *Muxer) TsStats(idx int) (stats TsStats) {
+	if idx >= 0 {
+		if idx < len(m.ts) {
+			stats = m.ts[idx].stats
+		}
+		return
+	}
+	for _, st := range m.ts {
+		stats.add(st.stats)
+	}
+	return
+}
+
+func (m *Muxer) streamTs(idx int) *streamTs {
+	for len(m.ts) <= idx {
+		m.ts = append(m.ts, streamTs{lastDts: util.AvNoPtsValue})
+	}
+	return &m.ts[idx]
+}
+
+// checkTs detect missing, invalid or non-monotonic timestamps of pkt in the output time base
+// and handle them by TsPolicy. Return drop if pkt must not be written
+func (m *Muxer) checkTs(pkt *media.Packet) (drop bool, err error) {
+	flags := outputFormatFlags(m.pOutFmtCtx)
+	if (flags & libavformat.AvfmtNotimestamps) != 0 {
+		return
+	}
+	idx := pkt.StreamIndex()
+	st := m.streamTs(idx)
+	pts, dts := pkt.RawPts(), pkt.RawDts()
+	var problem TsStats
+
+	if pts == util.AvNoPtsValue || dts == util.AvNoPtsValue {
+		problem.Missing++
+		switch {
+		case pts != util.AvNoPtsValue:
+			dts = pts
+		case dts != util.AvNoPtsValue:
+			pts = dts
+		case st.lastDts != util.AvNoPtsValue:
+			dts = st.lastDts + max64(pkt.RawDuration(), 1)
+			pts = dts
+		default:
+			dts, pts = 0, 0
+		}
+	}
+	if pts < dts {
+		problem.Invalid++
+		pts = dts
+	}
+	if st.lastDts != util.AvNoPtsValue {
+		// dts may repeat only if the format does not require strictly increasing timestamps
+		next := st.lastDts + 1
+		if (flags & libavformat.AvfmtTsNonstrict) != 0 {
+			next = st.lastDts
+		}
+		if dts < next {
+			problem.NonMonotonic++
+			pts = max64(pts, next)
+			dts = next
+		}
+	}
+
+	if problem == (TsStats{}) {
+		st.lastDts = dts
+		return
+	}
+	st.stats.add(problem)
+	fields := []logutil.Field{logutil.F("stream", idx), logutil.F("pts", pkt.RawPts()),
+		logutil.F("dts", pkt.RawDts()), logutil.F("last_dts", st.lastDts), logutil.F("policy", m.TsPolicy.String())}
+	switch m.TsPolicy {
+	case TsDrop:
+		st.stats.Dropped++
+		drop = true
+		m.log().Debug("Muxer Write: drop packet of invalid timestamps", fields...)
+	case TsError:
+		err = averror.New(fmt.Sprintf("Muxer Write: stream(%v) invalid timestamps pts(%v) dts(%v) after dts(%v)",
+			idx, pkt.RawPts(), pkt.RawDts(), st.lastDts), averror.CodeInvalidData)
+	default:
+		st.stats.Fixed++
+		m.log().Debug("Muxer Write: fix invalid timestamps", append(fields,
+			logutil.F("fixed_pts", pts), logutil.F("fixed_dts", dts))...)
+		pkt.SetRawPts(pts)
+		pkt.SetRawDts(dts)
+		st.lastDts = dts
+	}
+	return
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,68 @@
+package pipeline
+
+//#cgo pkg-config: libavcodec libavutil
+//#include <stdlib.h>
+//#include <libavcodec/avcodec.h>
+//#include <libavutil/pixdesc.h>
+import "C"
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/xueqing/ffmpeg-demo/encoder"
+	"github.com/xueqing/ffmpeg-demo/filter"
+	"github.com/xueqing/ffmpeg-demo/media"
+	"github.com/xueqing/goav/libavcodec"
+	"github.com/xueqing/goav/libavutil"
+)
+
+// encoderOutput constrain frames of the filter graph to formats supported by pEnc
+func encoderOutput(pEnc *libavcodec.AvCodec, mediaType libavutil.AvMediaType,
+	cfg *encoder.EncoderConfig) (out filter.Output, err error) {
+	out.MediaType = mediaType
+	pCodec := (*C.AVCodec)(unsafe.Pointer(pEnc))
+	if mediaType == libavutil.AvmediaTypeVideo {
+		if cfg != nil && cfg.PixelFormat != "" {
+			cName := C.CString(cfg.PixelFormat)
+			defer C.free(unsafe.Pointer(cName))
+			pixFmt := C.av_get_pix_fmt(cName)
+			if pixFmt == C.AV_PIX_FMT_NONE {
+				err = fmt.Errorf("unknown pixel format(%v)", cfg.PixelFormat)
+				return
+			}
+			out.PixelFormats = []libavcodec.AvPixelFormat{libavcodec.AvPixelFormat(pixFmt)}
+		} else {
+			out.PixelFormats = pEnc.PixFmts()
+		}
+		return
+	}
+
+	if cfg != nil && cfg.SampleFormat != "" {
+		cName := C.CString(cfg.SampleFormat)
+		defer C.free(unsafe.Pointer(cName))
+		sampleFmt := C.av_get_sample_fmt(cName)
+		if sampleFmt == C.AV_SAMPLE_FMT_NONE {
+			err = fmt.Errorf("unknown sample format(%v)", cfg.SampleFormat)
+			return
+		}
+		out.SampleFormats = []libavcodec.AvSampleFormat{libavcodec.AvSampleFormat(sampleFmt)}
+	} else {
+		out.SampleFormats = pEnc.SampleFmts()
+	}
+	if p := pCodec.supported_samplerates; p != nil {
+		for rates := (*[1 << 16]C.int)(unsafe.Pointer(p)); rates[len(out.SampleRates)] != 0; {
+			out.SampleRates = append(out.SampleRates, int(rates[len(out.SampleRates)]))
+		}
+	}
+	if p := pCodec.channel_layouts; p != nil {
+		for layouts := (*[1 << 16]C.uint64_t)(unsafe.Pointer(p)); layouts[len(out.ChannelLayouts)] != 0; {
+			out.ChannelLayouts = append(out.ChannelLayouts, uint64(layouts[len(out.ChannelLayouts)]))
+		}
+	}
+	return
+}
+
+// resetPictType let the encoder decide picture types instead of following the decoder
+func resetPictType(frame *media.Frame) {
+	(*C.AVFrame)(unsafe.Pointer(frame.AvFrame())).pict_type = C.AV_PICTURE_TYPE_NONE
+}
@@ -0,0 +1,105 @@
+package pipeline
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xueqing/ffmpeg-demo/encoder"
+	"github.com/xueqing/ffmpeg-demo/logutil"
+	"github.com/xueqing/ffmpeg-demo/muxer"
+)
+
+// Action decide what is done with packets of a input stream
+type Action int
+
+// Actions of stream rules
+const (
+	// Copy remux packets without decoding
+	Copy Action = iota
+	// Transcode decode, filter and encode packets
+	Transcode
+	// Drop discard the stream
+	Drop
+)
+
+// String return lower case action name
+func (a Action) String() string {
+	switch a {
+	case Copy:
+		return "copy"
+	case Transcode:
+		return "transcode"
+	case Drop:
+		return "drop"
+	}
+	return fmt.Sprintf("action(%d)", int(a))
+}
+
+// StreamRule describe how a input stream is written to the output
+type StreamRule struct {
+	Action Action
+	// Encoder select the encoder when Action is Transcode, nil transcode to the input codec
+	Encoder *encoder.EncoderConfig
+	// Filter is a libavfilter description applied before encoding, e.g. "scale=1280:-2",
+	// empty pass frames through. Frames are converted to formats supported by the encoder
+	Filter string
+}
+
+// Config configure a Pipeline
+type Config struct {
+	// InputFormat and OutputFormat are short names of container formats, empty to guess
+	InputFormat  string
+	OutputFormat string
+	// InputOptions are passed to the demuxer, e.g. probesize, rw_timeout
+	InputOptions map[string]interface{}
+	// OutputOptions are passed to the muxer when writing header, e.g. movflags
+	OutputOptions map[string]interface{}
+	// Metadata are container tags of the output, e.g. title, encoder
+	Metadata map[string]string
+	// TsPolicy handle packets of missing or non-monotonic timestamps, default fix them
+	TsPolicy muxer.TsPolicy
+
+	// Rules by input stream index, override the rules by media type below
+	Rules map[int]StreamRule
+	// Video and Audio rules apply to streams of the media type
+	Video StreamRule
+	Audio StreamRule
+	// Other rule apply to subtitle, data and attachment streams
+	Other StreamRule
+
+	// Staged run demux, decode, filter, encode and mux of every stream in their own
+	// goroutines connected by bounded queues, instead of one goroutine calling them in turn
+	Staged bool
+	// QueueSize is capacity of each queue between stages, default 8. A full queue
+	// blocks its producer, so a slow stage holds back the stages before it
+	QueueSize int
+
+	// DumpFormat dump information about input and output onto standard error
+	DumpFormat bool
+	// Logger is set on the demuxer, muxer, decoders and encoders, nil use logutil.Default()
+	Logger *logutil.Logger
+}
+
+// StreamSummary count packets and frames of a stream
+type StreamSummary struct {
+	InputIndex  int
+	OutputIndex int
+	Action      Action
+	// Codec is the name of the output codec
+	Codec string
+
+	PacketsIn     int64
+	FramesDecoded int64
+	FramesEncoded int64
+	PacketsOut    int64
+	BytesOut      int64
+	// Timestamps count output packets of invalid timestamps
+	Timestamps muxer.TsStats
+}
+
+// Summary is returned by Pipeline.Run
+type Summary struct {
+	Elapsed time.Duration
+	// Streams written to the output, dropped streams are not included
+	Streams []StreamSummary
+}
@@ -0,0 +1,268 @@
+// Package pipeline remux or transcode every stream of a input to a output by per-stream rules
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/xueqing/ffmpeg-demo/averror"
+	"github.com/xueqing/ffmpeg-demo/demuxer"
+	"github.com/xueqing/ffmpeg-demo/logutil"
+	"github.com/xueqing/ffmpeg-demo/media"
+	"github.com/xueqing/ffmpeg-demo/muxer"
+	"github.com/xueqing/goav/libavformat"
+	"github.com/xueqing/goav/libavutil"
+)
+
+// Pipeline read packets of a input and write them to a output, packets of each stream
+// are copied, transcoded or dropped by the stream rule. It is not safe for concurrent use
+type Pipeline struct {
+	cfg    Config
+	input  string
+	output string
+
+	demux *demuxer.Demuxer
+	mux   *muxer.Muxer
+	// cancel abort blocking reads of the demuxer
+	cancel context.CancelFunc
+	// streams by input stream index, nil for dropped streams
+	streams []*stream
+}
+
+// New open input and output and set up streams by cfg, call Run to process and Close after
+func New(input, output string, cfg Config) (p *Pipeline, err error) {
+	p = &Pipeline{
+		cfg:    cfg,
+		input:  input,
+		output: output,
+		demux:  demuxer.New(),
+		mux:    muxer.New(),
+	}
+	if err = p.open(); err != nil {
+		p.Close()
+		p = nil
+	}
+	return
+}
+
+func (p *Pipeline) open() (err error) {
+	p.demux.DumpFormat = p.cfg.DumpFormat
+	p.demux.Logger = p.cfg.Logger
+	p.mux.Logger = p.cfg.Logger
+	p.mux.TsPolicy = p.cfg.TsPolicy
+
+	var (
+		ctx    context.Context
+		unused []string
+	)
+	ctx, p.cancel = context.WithCancel(context.Background())
+	if unused, err = p.demux.OpenWithOptions(ctx, p.input, p.cfg.InputFormat, p.cfg.InputOptions); err != nil {
+		return
+	}
+	if len(unused) > 0 {
+		p.log().Warn("pipeline New: input options not found", logutil.F("options", unused))
+	}
+	if err = p.mux.Open(p.output, p.cfg.OutputFormat); err != nil {
+		return
+	}
+
+	iStreams, err := p.demux.Streams()
+	if err != nil {
+		return
+	}
+	p.streams = make([]*stream, len(iStreams))
+	var selected []int
+	for idx, pInStream := range iStreams {
+		rule := p.rule(pInStream)
+		switch rule.Action {
+		case Copy:
+			p.streams[idx], err = newCopyStream(p, pInStream)
+		case Transcode:
+			p.streams[idx], err = newTranscodeStream(p, pInStream, rule)
+		case Drop:
+			continue
+		default:
+			err = fmt.Errorf("pipeline New: stream(%v) unknown action(%v)", idx, rule.Action)
+		}
+		if err != nil {
+			return
+		}
+		selected = append(selected, idx)
+	}
+	if len(selected) == 0 {
+		err = fmt.Errorf("pipeline New: all streams are dropped")
+		return
+	}
+	// packets of dropped streams are not read
+	if _, err = p.demux.SelectStreams(demuxer.StreamIndexes(selected...)); err != nil {
+		return
+	}
+
+	for key, value := range p.cfg.Metadata {
+		if err = p.mux.SetMetadata(key, value); err != nil {
+			return
+		}
+	}
+
+	if p.cfg.DumpFormat {
+		p.mux.OutFormatContext().AvDumpFormat(0, p.output, 1)
+	}
+	return
+}
+
+// rule return the rule of pInStream, transcoding is only supported for video and audio
+func (p *Pipeline) rule(pInStream *libavformat.AvStream) StreamRule {
+	if rule, ok := p.cfg.Rules[pInStream.Index()]; ok {
+		return rule
+	}
+	switch libavutil.AvMediaType(pInStream.CodecParameters().CodecType()) {
+	case libavutil.AvmediaTypeVideo:
+		return p.cfg.Video
+	case libavutil.AvmediaTypeAudio:
+		return p.cfg.Audio
+	}
+	return p.cfg.Other
+}
+
+// stream return the stream of input stream index idx, nil if it is dropped or added after
+// opening (e.g. by inputs of AVFMTCTX_NOHEADER), packets of such streams are dropped
+func (p *Pipeline) stream(idx int) *stream {
+	if idx < 0 || idx >= len(p.streams) {
+		return nil
+	}
+	return p.streams[idx]
+}
+
+// log return Logger of config, or logutil.Default() if it is not set
+func (p *Pipeline) log() *logutil.Logger {
+	if p.cfg.Logger != nil {
+		return p.cfg.Logger
+	}
+	return logutil.Default()
+}
+
+// Close free streams, the demuxer and the muxer
+func (p *Pipeline) Close() {
+	for _, s := range p.streams {
+		if s != nil {
+			s.close()
+		}
+	}
+	p.streams = nil
+	if p.cancel != nil {
+		p.cancel()
+	}
+	if p.demux != nil {
+		p.demux.Close()
+		p.demux = nil
+	}
+	if p.mux != nil {
+		p.mux.Close()
+		p.mux = nil
+	}
+}
+
+// Run write header, process all packets of the input, flush every stream and write trailer.
+// Stages run in turn in the calling goroutine, or concurrently if Config.Staged is set.
+// Cancelling ctx aborts reading and returns ctx.Err(), the output is then incomplete.
+// Summary is returned even on error
+func (p *Pipeline) Run(ctx context.Context) (summary *Summary, err error) {
+	start := time.Now()
+	defer func() {
+		summary = p.summary(time.Since(start))
+	}()
+	defer p.watch(ctx)()
+
+	if err = p.mux.WriteHeader(p.cfg.OutputOptions); err != nil {
+		return
+	}
+	if p.cfg.Staged {
+		err = p.runStaged(ctx)
+	} else {
+		err = p.run(ctx)
+	}
+	if err != nil {
+		return
+	}
+	if ret := p.mux.WriteTrailer(); ret < 0 {
+		err = averror.New("Pipeline Run: write trailer", ret)
+		return
+	}
+	p.log().Info("Pipeline Run", logutil.F("input", p.input), logutil.F("output", p.output),
+		logutil.F("elapsed", time.Since(start)))
+	return
+}
+
+// watch abort blocking reads of the demuxer when ctx is done, until the returned func is called
+func (p *Pipeline) watch(ctx context.Context) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.cancel()
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+// run pass packets to their streams in the calling goroutine and flush every stream
+func (p *Pipeline) run(ctx context.Context) (err error) {
+	if err = p.readAll(ctx); err != nil {
+		return
+	}
+	for _, s := range p.streams {
+		if s == nil {
+			continue
+		}
+		if err = s.flush(); err != nil {
+			err = fmt.Errorf("Pipeline Run: flush stream(%v) error(%w)", s.summary.InputIndex, err)
+			return
+		}
+	}
+	return
+}
+
+// readAll pass packets to their streams until end of input
+func (p *Pipeline) readAll(ctx context.Context) (err error) {
+	var pkt *media.Packet
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if pkt, err = p.demux.Read(); err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			} else if errors.Is(err, averror.ErrEOF) {
+				err = nil
+			}
+			return
+		}
+		if s := p.stream(pkt.StreamIndex()); s != nil {
+			err = s.handle(pkt)
+		}
+		pkt.Free()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// summary collect summaries of output streams
+func (p *Pipeline) summary(elapsed time.Duration) *Summary {
+	summary := &Summary{Elapsed: elapsed}
+	for _, s := range p.streams {
+		if s != nil {
+			st := s.summary
+			st.Timestamps = p.mux.TsStats(st.OutputIndex)
+			summary.Streams = append(summary.Streams, st)
+		}
+	}
+	return summary
+}
@@ -0,0 +1,397 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/xueqing/ffmpeg-demo/averror"
+	"github.com/xueqing/ffmpeg-demo/media"
+)
+
+const defaultQueueSize = 8
+
+// group run stages in goroutines, the first error cancels the others
+type group struct {
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
+	// abort is called with cancel on the first error, e.g. to abort blocking reads
+	// which do not watch the context of the group
+	abort func()
+	once  sync.Once
+	err   error
+}
+
+// run call f in a goroutine, an error returned by f cancels the group at once.
+// done is called after f returns in any case, e.g. to free items left in the
+// input queue and close the output queue
+func (g *group) run(f func() error, done func()) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := f(); err != nil {
+			g.once.Do(func() {
+				g.err = err
+				g.cancel()
+				if g.abort != nil {
+					g.abort()
+				}
+			})
+		}
+		if done != nil {
+			done()
+		}
+	}()
+}
+
+// wait wait for all stages and return the first error
+func (g *group) wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
+}
+
+// muxItem is a packet queued to the mux stage, a nil pkt marks end of the stream
+type muxItem struct {
+	st  *stagedStream
+	pkt *media.Packet
+}
+
+// stagedStream is a stream with queues between its stages. Items are owned by the
+// receiver once sent, and freed by the sender if sending is cancelled
+type stagedStream struct {
+	*stream
+	// demux -> decode
+	packets chan *media.Packet
+	// decode -> filter
+	decoded chan *media.Frame
+	// filter -> encode
+	filtered chan *media.Frame
+
+	// packets waiting for interleaving in the mux stage
+	pending []*media.Packet
+	eos     bool
+}
+
+// runStaged run every stage in its own goroutine until end of input, stages of a stream
+// are flushed in turn after end of input. Cancelling ctx or an error of any stage stops
+// all of them, items left in the queues are freed
+func (p *Pipeline) runStaged(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	// Run only abort reads of the demuxer when ctx of caller is done
+	g := &group{cancel: cancel, abort: p.cancel}
+	size := p.cfg.QueueSize
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+
+	var (
+		staged = make([]*stagedStream, len(p.streams))
+		active []*stagedStream
+		// demux and encode stages send to the mux queue, it is closed after all of them exit
+		producers sync.WaitGroup
+	)
+	for idx, s := range p.streams {
+		if s != nil {
+			staged[idx] = &stagedStream{stream: s}
+			active = append(active, staged[idx])
+		}
+	}
+	muxQueue := make(chan muxItem, size*len(active))
+
+	for _, st := range active {
+		if st.rule.Action != Transcode {
+			continue
+		}
+		st.packets = make(chan *media.Packet, size)
+		st.decoded = make(chan *media.Frame, size)
+		st.filtered = make(chan *media.Frame, size)
+		st.setHandlers(ctx, muxQueue)
+
+		st := st
+		g.run(func() error { return st.decodeStage(ctx) }, func() {
+			freePackets(st.packets)
+			close(st.decoded)
+		})
+		g.run(func() error { return st.filterStage(ctx) }, func() {
+			freeFrames(st.decoded)
+			close(st.filtered)
+		})
+		producers.Add(1)
+		g.run(func() error { return st.encodeStage(ctx, muxQueue) }, func() {
+			freeFrames(st.filtered)
+			producers.Done()
+		})
+	}
+
+	producers.Add(1)
+	g.run(func() error { return p.demuxStage(ctx, staged, muxQueue) }, func() {
+		for _, st := range active {
+			if st.packets != nil {
+				close(st.packets)
+			}
+		}
+		producers.Done()
+	})
+	go func() {
+		producers.Wait()
+		close(muxQueue)
+	}()
+
+	il := &interleaver{streams: active, limit: cap(muxQueue)}
+	g.run(func() error { return il.run(ctx, muxQueue) }, func() {
+		for item := range muxQueue {
+			if item.pkt != nil {
+				item.pkt.Free()
+			}
+		}
+		il.free()
+	})
+	return g.wait()
+}
+
+// demuxStage read packets and queue them to the decode stage of transcoded streams,
+// or to the mux stage of copied streams
+func (p *Pipeline) demuxStage(ctx context.Context, staged []*stagedStream, muxQueue chan<- muxItem) (err error) {
+	var pkt *media.Packet
+	for {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		if pkt, err = p.demux.Read(); err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			} else if errors.Is(err, averror.ErrEOF) {
+				break
+			}
+			return
+		}
+		// streams added after opening are not in staged
+		var st *stagedStream
+		if idx := pkt.StreamIndex(); idx >= 0 && idx < len(staged) {
+			st = staged[idx]
+		}
+		if st == nil {
+			pkt.Free()
+			continue
+		}
+		st.summary.PacketsIn++
+		if st.packets != nil {
+			err = sendPacket(ctx, st.packets, pkt)
+		} else {
+			err = sendMux(ctx, muxQueue, muxItem{st, pkt})
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	// copied streams end with the input
+	for _, st := range staged {
+		if st != nil && st.packets == nil {
+			if err = sendMux(ctx, muxQueue, muxItem{st: st}); err != nil {
+				return
+			}
+		}
+	}
+	return nil
+}
+
+// setHandlers make decoder, graph and encoder queue their output to the next stage
+func (st *stagedStream) setHandlers(ctx context.Context, muxQueue chan<- muxItem) {
+	st.dec.FrameHandler = func(frame *media.Frame) error {
+		st.summary.FramesDecoded++
+		return sendFrame(ctx, st.decoded, frame)
+	}
+	st.graph.FrameHandler = func(output int, frame *media.Frame) error {
+		return sendFrame(ctx, st.filtered, frame)
+	}
+	st.enc.PacketHandler = func(pkt *media.Packet) error {
+		c, err := pkt.Clone()
+		if err != nil {
+			return err
+		}
+		return sendMux(ctx, muxQueue, muxItem{st, c})
+	}
+}
+
+func (st *stagedStream) decodeStage(ctx context.Context) error {
+	for pkt := range st.packets {
+		if err := ctx.Err(); err != nil {
+			pkt.Free()
+			return err
+		}
+		err := st.dec.Decode(pkt.AvPacket())
+		pkt.Free()
+		if err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return st.dec.Flush()
+}
+
+func (st *stagedStream) filterStage(ctx context.Context) error {
+	for frame := range st.decoded {
+		if err := ctx.Err(); err != nil {
+			frame.Free()
+			return err
+		}
+		err := st.graph.Handle(frame)
+		frame.Free()
+		if err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return st.graph.Flush()
+}
+
+func (st *stagedStream) encodeStage(ctx context.Context, muxQueue chan<- muxItem) error {
+	for frame := range st.filtered {
+		if err := ctx.Err(); err != nil {
+			frame.Free()
+			return err
+		}
+		err := st.encode(frame)
+		frame.Free()
+		if err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := st.enc.Flush(); err != nil {
+		return err
+	}
+	return sendMux(ctx, muxQueue, muxItem{st: st})
+}
+
+// interleaver write packets of all streams in dts order. A packet is written when every
+// stream not ended has a packet waiting, or when more than limit packets are waiting
+// (e.g. the encoder of a stream buffers many frames before output)
+type interleaver struct {
+	streams []*stagedStream
+	limit   int
+	pending int
+}
+
+func (il *interleaver) run(ctx context.Context, muxQueue <-chan muxItem) error {
+	for item := range muxQueue {
+		if err := ctx.Err(); err != nil {
+			if item.pkt != nil {
+				item.pkt.Free()
+			}
+			return err
+		}
+		if item.pkt == nil {
+			item.st.eos = true
+		} else {
+			item.st.pending = append(item.st.pending, item.pkt)
+			il.pending++
+		}
+		for il.pending > 0 && (il.pending > il.limit || il.ready()) {
+			if err := il.writeFirst(); err != nil {
+				return err
+			}
+		}
+	}
+	// producers exit without end of stream when cancelled
+	return ctx.Err()
+}
+
+// ready report whether every stream has a packet waiting or has ended
+func (il *interleaver) ready() bool {
+	for _, st := range il.streams {
+		if !st.eos && len(st.pending) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// writeFirst write the waiting packet of the smallest dts
+func (il *interleaver) writeFirst() error {
+	var first *stagedStream
+	for _, st := range il.streams {
+		if len(st.pending) > 0 && (first == nil || st.pending[0].Dts() < first.pending[0].Dts()) {
+			first = st
+		}
+	}
+	pkt := first.pending[0]
+	first.pending[0] = nil
+	first.pending = first.pending[1:]
+	il.pending--
+	err := first.write(pkt)
+	pkt.Free()
+	return err
+}
+
+// free free packets still waiting
+func (il *interleaver) free() {
+	for _, st := range il.streams {
+		for _, pkt := range st.pending {
+			pkt.Free()
+		}
+		st.pending = nil
+	}
+	il.pending = 0
+}
+
+// sendPacket queue pkt, it is freed if ctx is done first
+func sendPacket(ctx context.Context, queue chan<- *media.Packet, pkt *media.Packet) error {
+	select {
+	case queue <- pkt:
+		return nil
+	case <-ctx.Done():
+		pkt.Free()
+		return ctx.Err()
+	}
+}
+
+// sendFrame queue a reference of frame, frame itself is still owned by caller
+func sendFrame(ctx context.Context, queue chan<- *media.Frame, frame *media.Frame) error {
+	c, err := frame.Clone()
+	if err != nil {
+		return err
+	}
+	select {
+	case queue <- c:
+		return nil
+	case <-ctx.Done():
+		c.Free()
+		return ctx.Err()
+	}
+}
+
+// sendMux queue item to the mux stage, the packet is freed if ctx is done first
+func sendMux(ctx context.Context, queue chan<- muxItem, item muxItem) error {
+	select {
+	case queue <- item:
+		return nil
+	case <-ctx.Done():
+		if item.pkt != nil {
+			item.pkt.Free()
+		}
+		return ctx.Err()
+	}
+}
+
+// freePackets free packets left in queue until it is closed
+func freePackets(queue <-chan *media.Packet) {
+	for pkt := range queue {
+		pkt.Free()
+	}
+}
+
+// freeFrames free frames left in queue until it is closed
+func freeFrames(queue <-chan *media.Frame) {
+	for frame := range queue {
+		frame.Free()
+	}
+}
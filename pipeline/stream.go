@@ -0,0 +1,189 @@
+package pipeline
+
+import (
+	"fmt"
+
+	"github.com/xueqing/ffmpeg-demo/averror"
+	"github.com/xueqing/ffmpeg-demo/decoder"
+	"github.com/xueqing/ffmpeg-demo/encoder"
+	"github.com/xueqing/ffmpeg-demo/filter"
+	"github.com/xueqing/ffmpeg-demo/media"
+	"github.com/xueqing/ffmpeg-demo/muxer"
+	"github.com/xueqing/goav/libavcodec"
+	"github.com/xueqing/goav/libavformat"
+	"github.com/xueqing/goav/libavutil"
+)
+
+// stream carry packets of a input stream to its output stream
+type stream struct {
+	summary StreamSummary
+	rule    StreamRule
+
+	pInStream  *libavformat.AvStream
+	pOutStream *libavformat.AvStream
+	mux        *muxer.Muxer
+
+	// transcode only
+	dec   *decoder.Decoder
+	graph *filter.Graph
+	enc   *encoder.Encoder
+}
+
+// newCopyStream add a output stream receiving packets of pInStream as they are
+func newCopyStream(p *Pipeline, pInStream *libavformat.AvStream) (s *stream, err error) {
+	s = &stream{
+		rule:      StreamRule{Action: Copy},
+		pInStream: pInStream,
+		mux:       p.mux,
+	}
+	if s.pOutStream, err = p.mux.AddStreamCopy(pInStream); err != nil {
+		return
+	}
+	codecID := pInStream.CodecParameters().CodecID()
+	s.summary = StreamSummary{
+		InputIndex:  pInStream.Index(),
+		OutputIndex: s.pOutStream.Index(),
+		Action:      Copy,
+		Codec:       libavcodec.AvcodecGetName(codecID),
+	}
+	return
+}
+
+// newTranscodeStream open decoder, filter graph and encoder of pInStream and add the output stream
+func newTranscodeStream(p *Pipeline, pInStream *libavformat.AvStream, rule StreamRule) (s *stream, err error) {
+	s = &stream{
+		rule:      rule,
+		pInStream: pInStream,
+		mux:       p.mux,
+		dec:       decoder.New(p.demux.InFormatContext()),
+		enc:       encoder.New(),
+	}
+	s.dec.Logger = p.cfg.Logger
+	s.enc.Logger = p.cfg.Logger
+	if err = s.dec.Open(pInStream); err != nil {
+		return
+	}
+	pDecCtx := s.dec.DecCodecContext()
+	mediaType := libavutil.AvMediaType(pDecCtx.CodecType())
+
+	// frames are converted by the graph to formats the encoder accepts
+	pEnc, err := rule.Encoder.FindEncoder(libavcodec.AvCodecID(pDecCtx.CodecID()))
+	if err != nil {
+		err = averror.Wrap(fmt.Sprintf("pipeline New: stream(%v)", pInStream.Index()), averror.CodeEncoderNotFound, err)
+		return
+	}
+	output, err := encoderOutput(pEnc, mediaType, rule.Encoder)
+	if err != nil {
+		err = fmt.Errorf("pipeline New: stream(%v) %w", pInStream.Index(), err)
+		return
+	}
+	desc := rule.Filter
+	if desc == "" {
+		desc = "null"
+		if mediaType == libavutil.AvmediaTypeAudio {
+			desc = "anull"
+		}
+	}
+	input := filter.InputFromCodecContext("", pDecCtx, s.dec.TimeBase())
+	if s.graph, err = filter.New(desc, []filter.Input{input}, []filter.Output{output}); err != nil {
+		return
+	}
+
+	format := s.graph.OutputFormat(0)
+	params := encoder.Params{
+		MediaType:   mediaType,
+		CodecID:     libavcodec.AvCodecID(pDecCtx.CodecID()),
+		StreamIndex: int(p.mux.OutFormatContext().NbStreams()),
+		// video time_base can be set to whatever is handy and supported by encoder
+		TimeBase:          format.TimeBase,
+		Width:             format.Width,
+		Height:            format.Height,
+		PixelFormat:       format.PixelFormat,
+		SampleAspectRatio: format.SampleAspectRatio,
+		FrameRate:         format.FrameRate,
+		SampleRate:        format.SampleRate,
+		SampleFormat:      format.SampleFormat,
+		ChannelLayout:     format.ChannelLayout,
+		GlobalHeader:      p.mux.GlobalHeader(),
+	}
+	if mediaType == libavutil.AvmediaTypeVideo && format.FrameRate.Num() > 0 && format.FrameRate.Den() > 0 {
+		params.TimeBase = libavcodec.AvInvQ(format.FrameRate)
+	} else if mediaType == libavutil.AvmediaTypeAudio {
+		params.TimeBase = libavcodec.NewAvRational(1, format.SampleRate)
+	}
+	if err = s.enc.Open(params, rule.Encoder); err != nil {
+		return
+	}
+	if s.pOutStream, err = p.mux.AddStreamFromEncoder(s.enc); err != nil {
+		return
+	}
+	s.pOutStream.SetDisposition(pInStream.Disposition())
+
+	s.summary = StreamSummary{
+		InputIndex:  pInStream.Index(),
+		OutputIndex: s.pOutStream.Index(),
+		Action:      Transcode,
+		Codec:       pEnc.Name(),
+	}
+	s.dec.FrameHandler = func(frame *media.Frame) error {
+		s.summary.FramesDecoded++
+		return s.graph.Handle(frame)
+	}
+	s.graph.FrameHandler = func(output int, frame *media.Frame) error {
+		return s.encode(frame)
+	}
+	s.enc.PacketHandler = s.write
+	return
+}
+
+// close free the codecs and the graph, the output stream is freed with the muxer
+func (s *stream) close() {
+	if s.dec != nil {
+		s.dec.Close()
+	}
+	if s.graph != nil {
+		s.graph.Close()
+	}
+	if s.enc != nil {
+		s.enc.Close()
+	}
+}
+
+// handle copy or decode pkt read from the input stream
+func (s *stream) handle(pkt *media.Packet) error {
+	s.summary.PacketsIn++
+	if s.rule.Action == Copy {
+		return s.write(pkt)
+	}
+	return s.dec.Decode(pkt.AvPacket())
+}
+
+// encode rescale pts of filtered frame to the encoder time base and encode it
+func (s *stream) encode(frame *media.Frame) error {
+	frame.RescalePts(s.enc.TimeBase())
+	resetPictType(frame)
+	s.summary.FramesEncoded++
+	return s.enc.Encode(frame.AvFrame())
+}
+
+// flush drain frames and packets buffered in decoder, graph and encoder
+func (s *stream) flush() (err error) {
+	if s.rule.Action == Copy {
+		return
+	}
+	if err = s.dec.Flush(); err != nil {
+		return
+	}
+	if err = s.graph.Flush(); err != nil {
+		return
+	}
+	return s.enc.Flush()
+}
+
+// write mux pkt to the output stream, timestamps are rescaled by the muxer
+func (s *stream) write(pkt *media.Packet) error {
+	s.summary.PacketsOut++
+	s.summary.BytesOut += int64(pkt.Size())
+	pkt.SetStreamIndex(s.pOutStream.Index())
+	return s.mux.Write(pkt)
+}
@@ -0,0 +1,205 @@
+// Package resample convert audio sample rate, sample format and channel layout with libswresample
+package resample
+
+//#cgo pkg-config: libswresample libavutil
+//#include <libswresample/swresample.h>
+//#include <libavutil/channel_layout.h>
+//#include <libavutil/frame.h>
+//#include <libavutil/mathematics.h>
+//
+//static int convert_frame(SwrContext *s, AVFrame *out, const AVFrame *in)
+//{
+//    int ret = swr_convert(s, out->extended_data, out->nb_samples,
+//                          in ? (const uint8_t **)in->extended_data : NULL, in ? in->nb_samples : 0);
+//    if (ret >= 0)
+//        out->nb_samples = ret;
+//    return ret;
+//}
+import "C"
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/xueqing/ffmpeg-demo/averror"
+	"github.com/xueqing/ffmpeg-demo/media"
+	"github.com/xueqing/ffmpeg-demo/util"
+	"github.com/xueqing/goav/libavcodec"
+)
+
+// Format describe audio samples
+type Format struct {
+	SampleRate    int
+	SampleFormat  libavcodec.AvSampleFormat
+	ChannelLayout uint64
+}
+
+// FrameFormat return format of audio frame, the default layout is used if the
+// channel layout of frame is unknown
+func FrameFormat(frame *media.Frame) Format {
+	layout := frame.ChannelLayout()
+	if layout == 0 {
+		layout = uint64(C.av_get_default_channel_layout(C.int(frame.Channels())))
+	}
+	return Format{
+		SampleRate:    frame.SampleRate(),
+		SampleFormat:  frame.SampleFormat(),
+		ChannelLayout: layout,
+	}
+}
+
+// Resampler convert audio frames to the output format, it is set up from the first frame
+type Resampler struct {
+	// frame is freed after handler returns, call frame.Clone() to keep it
+	FrameHandler func(frame *media.Frame) (err error)
+
+	pSwrCtx *C.SwrContext
+	in      Format
+	out     Format
+	// time base of input pts
+	inTimeBase libavcodec.AvRational
+}
+
+// New create a Resampler converting to out
+func New(out Format) (r *Resampler, err error) {
+	if out.SampleRate <= 0 || C.av_get_channel_layout_nb_channels(C.uint64_t(out.ChannelLayout)) <= 0 {
+		err = fmt.Errorf("resample New: invalid output sample rate(%v) channel layout(%#x)",
+			out.SampleRate, out.ChannelLayout)
+		return
+	}
+	r = &Resampler{out: out}
+	return
+}
+
+// OutFormat return the output format
+func (r *Resampler) OutFormat() Format {
+	return r.out
+}
+
+// TimeBase return time base of output frames, 1/output sample rate
+func (r *Resampler) TimeBase() libavcodec.AvRational {
+	return libavcodec.NewAvRational(1, r.out.SampleRate)
+}
+
+// Close free the SwrContext
+func (r *Resampler) Close() {
+	if r.pSwrCtx != nil {
+		C.swr_free(&r.pSwrCtx)
+	}
+}
+
+// Delay return the number of input samples buffered in the resampler, in output sample rate
+func (r *Resampler) Delay() int64 {
+	if r.pSwrCtx == nil {
+		return 0
+	}
+	return int64(C.swr_get_delay(r.pSwrCtx, C.int64_t(r.out.SampleRate)))
+}
+
+// init allocate SwrContext converting frames of in format
+func (r *Resampler) init(in Format, inTimeBase libavcodec.AvRational) (err error) {
+	r.pSwrCtx = C.swr_alloc_set_opts(nil,
+		C.int64_t(r.out.ChannelLayout), C.enum_AVSampleFormat(r.out.SampleFormat), C.int(r.out.SampleRate),
+		C.int64_t(in.ChannelLayout), C.enum_AVSampleFormat(in.SampleFormat), C.int(in.SampleRate),
+		0, nil)
+	if r.pSwrCtx == nil {
+		err = averror.New("Resampler init: alloc swr context", averror.CodeNoMem)
+		return
+	}
+	if ret := int(C.swr_init(r.pSwrCtx)); ret < 0 {
+		C.swr_free(&r.pSwrCtx)
+		err = averror.New("Resampler init: init swr context", ret)
+		return
+	}
+	r.in = in
+	r.inTimeBase = inTimeBase
+	return
+}
+
+// Convert convert frame to the output format, the input frame is not freed.
+// Return nil frame if all samples are buffered. Must call frame.Free() after use
+func (r *Resampler) Convert(frame *media.Frame) (out *media.Frame, err error) {
+	in := FrameFormat(frame)
+	if r.pSwrCtx == nil {
+		if err = r.init(in, frame.TimeBase()); err != nil {
+			return
+		}
+	} else if in != r.in {
+		err = fmt.Errorf("Resampler Convert: input format changed from %+v to %+v", r.in, in)
+		return
+	}
+
+	// pts in 1/(in rate*out rate) keep sub sample accuracy, as libavfilter does. The product
+	// of rates overflows int so it is not used as a AVRational, av_rescale compute in int64
+	pts := util.AvNoPtsValue
+	if frame.Pts() != util.AvNoPtsValue && r.inTimeBase.Den() > 0 {
+		pts = int64(C.av_rescale(C.int64_t(frame.Pts()),
+			C.int64_t(r.inTimeBase.Num())*C.int64_t(in.SampleRate)*C.int64_t(r.out.SampleRate),
+			C.int64_t(r.inTimeBase.Den())))
+	}
+	return r.convert((*C.AVFrame)(unsafe.Pointer(frame.AvFrame())), frame.NbSamples(), pts)
+}
+
+// convert convert pFrame, or drain buffered samples if pFrame is nil
+func (r *Resampler) convert(pFrame *C.AVFrame, nbSamples int, pts int64) (out *media.Frame, err error) {
+	n := int(C.swr_get_out_samples(r.pSwrCtx, C.int(nbSamples)))
+	if n < 0 {
+		err = averror.New("Resampler Convert: get out samples", n)
+		return
+	}
+	if n == 0 {
+		return
+	}
+	if out, err = media.NewAudioFrame(n, r.out.SampleFormat, r.out.SampleRate, r.out.ChannelLayout); err != nil {
+		return
+	}
+	// pts of the first output sample in 1/(in rate*out rate), it is extrapolated from
+	// previous output if pts is undefined
+	nextPts := int64(C.swr_next_pts(r.pSwrCtx, C.int64_t(pts)))
+	ret := int(C.convert_frame(r.pSwrCtx, (*C.AVFrame)(unsafe.Pointer(out.AvFrame())), pFrame))
+	if ret <= 0 {
+		out.Free()
+		out = nil
+		if ret < 0 {
+			err = averror.New("Resampler Convert", ret)
+		}
+		return
+	}
+
+	out.SetPts(int64(C.av_rescale(C.int64_t(nextPts), 1, C.int64_t(r.in.SampleRate))))
+	out.SetTimeBase(r.TimeBase())
+	return
+}
+
+// Handle convert frame and pass the output to FrameHandler, it can be used as FrameHandler
+// of decoder.Decoder. The input frame is not freed
+func (r *Resampler) Handle(frame *media.Frame) (err error) {
+	var out *media.Frame
+	if out, err = r.Convert(frame); err != nil || out == nil {
+		return
+	}
+	if r.FrameHandler != nil {
+		err = r.FrameHandler(out)
+	}
+	out.Free()
+	return
+}
+
+// Flush drain samples delayed in the resampler through FrameHandler at end of stream
+func (r *Resampler) Flush() (err error) {
+	if r.pSwrCtx == nil {
+		return
+	}
+	var out *media.Frame
+	for {
+		if out, err = r.convert(nil, 0, util.AvNoPtsValue); err != nil || out == nil {
+			return
+		}
+		if r.FrameHandler != nil {
+			err = r.FrameHandler(out)
+		}
+		out.Free()
+		if err != nil {
+			return
+		}
+	}
+}
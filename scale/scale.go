@@ -0,0 +1,278 @@
+// Package scale convert video size and pixel format with libswscale
+package scale
+
+//#cgo pkg-config: libswscale libavutil
+//#include <libswscale/swscale.h>
+//#include <libavutil/frame.h>
+//#include <libavutil/imgutils.h>
+//#include <libavutil/pixdesc.h>
+//
+//// plane pointers of f at pixel (x, y), x and y must be aligned to chroma subsampling
+//static void plane_offsets(const AVFrame *f, int x, int y, uint8_t *data[4])
+//{
+//    const AVPixFmtDescriptor *desc = av_pix_fmt_desc_get(f->format);
+//    int steps[4], i;
+//
+//    av_image_fill_max_pixsteps(steps, NULL, desc);
+//    for (i = 0; i < 4; i++) {
+//        int chroma = i == 1 || i == 2;
+//        if (!f->data[i]) {
+//            data[i] = NULL;
+//            continue;
+//        }
+//        data[i] = f->data[i] + (y >> (chroma ? desc->log2_chroma_h : 0)) * f->linesize[i] +
+//                  (x >> (chroma ? desc->log2_chroma_w : 0)) * steps[i];
+//    }
+//}
+//
+//// scale the sw x sh rect of in at (sx, sy) into out at (dx, dy)
+//static int scale_rect(struct SwsContext *s, const AVFrame *in, int sx, int sy, int sh,
+//                      AVFrame *out, int dx, int dy)
+//{
+//    uint8_t *src[4], *dst[4];
+//
+//    plane_offsets(in, sx, sy, src);
+//    plane_offsets(out, dx, dy, dst);
+//    return sws_scale(s, (const uint8_t *const *)src, in->linesize, 0, sh, dst, out->linesize);
+//}
+//
+//static int fill_black(AVFrame *f)
+//{
+//    ptrdiff_t linesize[4];
+//    int i;
+//
+//    for (i = 0; i < 4; i++)
+//        linesize[i] = f->linesize[i];
+//    return av_image_fill_black(f->data, linesize, f->format, f->color_range, f->width, f->height);
+//}
+import "C"
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/xueqing/ffmpeg-demo/averror"
+	"github.com/xueqing/ffmpeg-demo/media"
+	"github.com/xueqing/goav/libavcodec"
+)
+
+// Algorithm is scaling algorithm (SWS_xxx)
+type Algorithm int
+
+// SWS_xxx
+const (
+	FastBilinear Algorithm = 0x1
+	Bilinear     Algorithm = 0x2
+	Bicubic      Algorithm = 0x4
+	Point        Algorithm = 0x10
+	Area         Algorithm = 0x20
+	Lanczos      Algorithm = 0x200
+)
+
+// Mode decide how the picture is placed when aspect ratio of input and output differs
+type Mode int
+
+// Modes of scaling
+const (
+	// Stretch scale to the output size, the sample aspect ratio is changed to keep display aspect ratio
+	Stretch Mode = iota
+	// Fit scale to fit in the output size keeping aspect ratio, borders are padded with black
+	Fit
+	// Crop scale to fill the output size keeping aspect ratio, the center is kept
+	Crop
+)
+
+// Config describe output frames of Scaler
+type Config struct {
+	// Width and Height of output frames, 0 keep size of input frames
+	Width  int
+	Height int
+	// PixelFormat of output frames
+	PixelFormat libavcodec.AvPixelFormat
+	// Algorithm default to Bicubic
+	Algorithm Algorithm
+	Mode      Mode
+}
+
+// rect is a region of picture
+type rect struct {
+	x, y, w, h int
+}
+
+// Scaler scale video frames, the scaling context is set up from the first frame
+// and again when the input size or pixel format changes
+type Scaler struct {
+	// frame is freed after handler returns, call frame.Clone() to keep it
+	FrameHandler func(frame *media.Frame) (err error)
+
+	cfg     Config
+	pSwsCtx *C.struct_SwsContext
+}
+
+// New create a Scaler
+func New(cfg Config) (s *Scaler, err error) {
+	if cfg.Width < 0 || cfg.Height < 0 || (cfg.Width == 0) != (cfg.Height == 0) {
+		err = fmt.Errorf("scale New: invalid output size(%vx%v)", cfg.Width, cfg.Height)
+		return
+	}
+	if C.sws_isSupportedOutput(C.enum_AVPixelFormat(cfg.PixelFormat)) == 0 {
+		err = fmt.Errorf("scale New: output pixel format(%v) is not supported", cfg.PixelFormat)
+		return
+	}
+	if cfg.Algorithm == 0 {
+		cfg.Algorithm = Bicubic
+	}
+	s = &Scaler{cfg: cfg}
+	return
+}
+
+// Close free the scaling context
+func (s *Scaler) Close() {
+	if s.pSwsCtx != nil {
+		C.sws_freeContext(s.pSwsCtx)
+		s.pSwsCtx = nil
+	}
+}
+
+// Scale convert frame to the output size and pixel format, the input frame is not freed.
+// pts and other properties are copied. Must call frame.Free() after use
+func (s *Scaler) Scale(frame *media.Frame) (out *media.Frame, err error) {
+	pIn := (*C.AVFrame)(unsafe.Pointer(frame.AvFrame()))
+	if C.sws_isSupportedInput(C.enum_AVPixelFormat(pIn.format)) == 0 {
+		err = fmt.Errorf("Scaler Scale: input pixel format(%v) is not supported", pIn.format)
+		return
+	}
+	width, height := s.cfg.Width, s.cfg.Height
+	if width == 0 {
+		width, height = int(pIn.width), int(pIn.height)
+	}
+	src, dst := s.layout(pIn, width, height)
+
+	// cached context is reused when the parameters are unchanged
+	s.pSwsCtx = C.sws_getCachedContext(s.pSwsCtx,
+		C.int(src.w), C.int(src.h), C.enum_AVPixelFormat(pIn.format),
+		C.int(dst.w), C.int(dst.h), C.enum_AVPixelFormat(s.cfg.PixelFormat),
+		C.int(s.cfg.Algorithm), nil, nil, nil)
+	if s.pSwsCtx == nil {
+		err = fmt.Errorf("Scaler Scale: get context from %vx%v(%v) to %vx%v(%v) error",
+			src.w, src.h, pIn.format, dst.w, dst.h, s.cfg.PixelFormat)
+		return
+	}
+
+	if out, err = media.NewVideoFrame(width, height, s.cfg.PixelFormat); err != nil {
+		return
+	}
+	pOut := (*C.AVFrame)(unsafe.Pointer(out.AvFrame()))
+	if ret := C.av_frame_copy_props(pOut, pIn); ret < 0 {
+		err = averror.New("Scaler Scale: copy frame props", int(ret))
+		goto fail
+	}
+	if dst.w != width || dst.h != height {
+		if ret := C.fill_black(pOut); ret < 0 {
+			err = averror.New("Scaler Scale: fill black", int(ret))
+			goto fail
+		}
+	}
+	if ret := C.scale_rect(s.pSwsCtx, pIn, C.int(src.x), C.int(src.y), C.int(src.h),
+		pOut, C.int(dst.x), C.int(dst.y)); ret < 0 {
+		err = averror.New("Scaler Scale", int(ret))
+		goto fail
+	}
+	pOut.sample_aspect_ratio = s.sampleAspectRatio(pIn, src, dst)
+	out.SetTimeBase(frame.TimeBase())
+	return
+
+fail:
+	out.Free()
+	out = nil
+	return
+}
+
+// layout return the source rect of in and the destination rect in output frame of w x h
+func (s *Scaler) layout(in *C.AVFrame, w, h int) (src, dst rect) {
+	src = rect{w: int(in.width), h: int(in.height)}
+	dst = rect{w: w, h: h}
+	if s.cfg.Mode == Stretch {
+		return
+	}
+
+	// display width and height of input, in square pixels
+	sarNum, sarDen := int64(in.sample_aspect_ratio.num), int64(in.sample_aspect_ratio.den)
+	if sarNum <= 0 || sarDen <= 0 {
+		sarNum, sarDen = 1, 1
+	}
+	dispW, dispH := int64(src.w)*sarNum, int64(src.h)*sarDen
+
+	// offsets and sizes are aligned to chroma subsampling of the pixel format
+	srcAlignW, srcAlignH := chromaAlign(C.enum_AVPixelFormat(in.format))
+	dstAlignW, dstAlignH := chromaAlign(C.enum_AVPixelFormat(s.cfg.PixelFormat))
+	switch s.cfg.Mode {
+	case Fit:
+		if dispW*int64(h) > dispH*int64(w) {
+			dst.h = alignSize(int(int64(w)*dispH/dispW), dstAlignH, h)
+		} else {
+			dst.w = alignSize(int(int64(h)*dispW/dispH), dstAlignW, w)
+		}
+		dst.x, dst.y = alignDown((w-dst.w)/2, dstAlignW), alignDown((h-dst.h)/2, dstAlignH)
+	case Crop:
+		if dispW*int64(h) > dispH*int64(w) {
+			src.w = alignSize(int(int64(src.w)*dispH*int64(w)/(dispW*int64(h))), srcAlignW, src.w)
+		} else {
+			src.h = alignSize(int(int64(src.h)*dispW*int64(h)/(dispH*int64(w))), srcAlignH, src.h)
+		}
+		src.x, src.y = alignDown((int(in.width)-src.w)/2, srcAlignW), alignDown((int(in.height)-src.h)/2, srcAlignH)
+	}
+	return
+}
+
+// sampleAspectRatio return sample aspect ratio of output keeping display aspect ratio
+func (s *Scaler) sampleAspectRatio(in *C.AVFrame, src, dst rect) (sar C.AVRational) {
+	if s.cfg.Mode != Stretch {
+		return C.AVRational{num: 1, den: 1}
+	}
+	if in.sample_aspect_ratio.num <= 0 || in.sample_aspect_ratio.den <= 0 {
+		return C.AVRational{num: 0, den: 1}
+	}
+	C.av_reduce(&sar.num, &sar.den,
+		C.int64_t(in.sample_aspect_ratio.num)*C.int64_t(src.w)*C.int64_t(dst.h),
+		C.int64_t(in.sample_aspect_ratio.den)*C.int64_t(src.h)*C.int64_t(dst.w), 1<<30)
+	return
+}
+
+// Handle scale frame and pass the output to FrameHandler, it can be used as FrameHandler
+// of decoder.Decoder. The input frame is not freed
+func (s *Scaler) Handle(frame *media.Frame) (err error) {
+	var out *media.Frame
+	if out, err = s.Scale(frame); err != nil {
+		return
+	}
+	if s.FrameHandler != nil {
+		err = s.FrameHandler(out)
+	}
+	out.Free()
+	return
+}
+
+// chromaAlign return horizontal and vertical subsampling of chroma planes of pixFmt
+func chromaAlign(pixFmt C.enum_AVPixelFormat) (w, h int) {
+	desc := C.av_pix_fmt_desc_get(pixFmt)
+	if desc == nil {
+		return 1, 1
+	}
+	return 1 << uint(desc.log2_chroma_w), 1 << uint(desc.log2_chroma_h)
+}
+
+// alignDown round v down to a multiple of align
+func alignDown(v, align int) int {
+	return v - v%align
+}
+
+// alignSize round v down to a multiple of align, at least align and at most limit
+func alignSize(v, align, limit int) int {
+	if v = alignDown(v, align); v < align {
+		v = align
+	}
+	if v > limit {
+		v = limit
+	}
+	return v
+}
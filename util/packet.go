@@ -0,0 +1,15 @@
+package util
+
+//#cgo pkg-config: libavcodec
+//#include <libavcodec/avcodec.h>
+import "C"
+import (
+	"unsafe"
+
+	"github.com/xueqing/goav/libavcodec"
+)
+
+// AvPacketFree free the packet allocated by libavcodec.AvPacketAlloc, unreferencing its data
+func AvPacketFree(pPkt *libavcodec.AvPacket) {
+	C.av_packet_free((**C.AVPacket)(unsafe.Pointer(&pPkt)))
+}
@@ -0,0 +1,28 @@
+package util
+
+import (
+	"time"
+
+	"github.com/xueqing/goav/libavcodec"
+)
+
+// AvNoPtsValue is AV_NOPTS_VALUE, undefined timestamp value
+const AvNoPtsValue int64 = -0x7fffffffffffffff - 1
+
+// AvTimeBase is AV_TIME_BASE, internal time base of libavformat in microseconds
+const AvTimeBase = 1000000
+
+var nanosecondTimeBase = libavcodec.NewAvRational(1, int(time.Second))
+
+// DurationToTs convert d to timestamp in time base tb, rounding to nearest
+func DurationToTs(d time.Duration, tb libavcodec.AvRational) int64 {
+	return libavcodec.AVRescaleQRnd(int64(d), nanosecondTimeBase, tb,
+		libavcodec.AvRoundNearInf|libavcodec.AvRoundPassMinmax)
+}
+
+// TsToDuration convert timestamp ts in time base tb to time.Duration.
+// AvNoPtsValue is kept as math.MinInt64
+func TsToDuration(ts int64, tb libavcodec.AvRational) time.Duration {
+	return time.Duration(libavcodec.AVRescaleQRnd(ts, tb, nanosecondTimeBase,
+		libavcodec.AvRoundNearInf|libavcodec.AvRoundPassMinmax))
+}
@@ -1,24 +1,162 @@
 package util
 
+//#cgo pkg-config: libavutil
+//#include <stdlib.h>
+//#include <libavutil/dict.h>
+//#include <libavutil/opt.h>
+//
+//// type of option name of obj or its children (e.g. priv_data), -1 if not found
+//static int option_type(void *obj, const char *name)
+//{
+//    const AVOption *o = av_opt_find(obj, name, NULL, 0, AV_OPT_SEARCH_CHILDREN);
+//    return o ? (int)o->type : -1;
+//}
+import "C"
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
 
+	"github.com/xueqing/ffmpeg-demo/averror"
 	"github.com/xueqing/goav/libavutil"
 )
 
-// GetAVDictionaryFromMap convert map to libavutil.Dictionary
+// Flags is value of a flags option, e.g. movflags: Flags{"faststart"} sets faststart and keeps
+// the default flags, a name prefixed with "-" unsets it
+type Flags []string
+
+// String format flags like "+faststart-empty_moov"
+func (f Flags) String() string {
+	var s string
+	for _, name := range f {
+		if !strings.HasPrefix(name, "+") && !strings.HasPrefix(name, "-") {
+			name = "+" + name
+		}
+		s += name
+	}
+	return s
+}
+
+// OptionValue format v as value of a libav* option. Supported types are integers, floats,
+// bool (as 1 or 0), time.Duration, string and Flags.
+// time.Duration is formatted as microseconds, which is right for int64 options counted in
+// microseconds (e.g. rw_timeout, max_interleave_delta) but not for AV_OPT_TYPE_DURATION
+// options (e.g. hls_time, segment_time) which take a bare number as seconds, see
+// GetAVDictionaryForObject
+func OptionValue(v interface{}) (s string, err error) {
+	switch v := v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		s = fmt.Sprint(v)
+	case float32:
+		s = strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		s = strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		s = "0"
+		if v {
+			s = "1"
+		}
+	case time.Duration:
+		s = strconv.FormatInt(v.Microseconds(), 10)
+	case string:
+		s = v
+	case Flags:
+		s = v.String()
+	default:
+		err = fmt.Errorf("unsupported type(%T)", v)
+	}
+	return
+}
+
+// durationSeconds format d as seconds with microsecond precision, e.g. "-1.500000",
+// as parsed by av_parse_time for AV_OPT_TYPE_DURATION options
+func durationSeconds(d time.Duration) string {
+	us, sign := d.Microseconds(), ""
+	if us < 0 {
+		us, sign = -us, "-"
+	}
+	return fmt.Sprintf("%s%d.%06d", sign, us/1e6, us%1e6)
+}
+
+// optionValueFor format v as value of option key of obj, time.Duration is formatted as
+// seconds if the option is AV_OPT_TYPE_DURATION
+func optionValueFor(obj unsafe.Pointer, key string, v interface{}) (string, error) {
+	if d, ok := v.(time.Duration); ok && obj != nil {
+		cKey := C.CString(key)
+		defer C.free(unsafe.Pointer(cKey))
+		if C.option_type(obj, cKey) == C.AV_OPT_TYPE_DURATION {
+			return durationSeconds(d), nil
+		}
+	}
+	return OptionValue(v)
+}
+
+// GetAVDictionaryFromMap convert map to libavutil.Dictionary, values are formatted by OptionValue.
+// must call d.AvDictFree() after use
 func GetAVDictionaryFromMap(m map[string]interface{}) (d *libavutil.AvDictionary, err error) {
+	return GetAVDictionaryForObject(nil, m)
+}
+
+// GetAVDictionaryForObject convert map to libavutil.Dictionary of options of obj, a struct
+// with AVClass such as AVFormatContext or AVCodecContext. Values are formatted by OptionValue,
+// except time.Duration of AV_OPT_TYPE_DURATION options of obj or its children (e.g. private
+// options of the muxer) which is formatted as seconds. Must call d.AvDictFree() after use
+func GetAVDictionaryForObject(obj unsafe.Pointer, m map[string]interface{}) (d *libavutil.AvDictionary, err error) {
 	for k, v := range m {
-		switch v.(type) {
-		case int64:
-			d.AvDictSetInt(k, v.(int64), 0)
-		case string:
-			d.AvDictSet(k, v.(string), 0)
-		default:
-			err = fmt.Errorf("GetAVDictionaryFromMap: unsupported type(%t)", v)
+		var value string
+		if value, err = optionValueFor(obj, k, v); err != nil {
+			err = fmt.Errorf("GetAVDictionaryFromMap: key(%v) %v", k, err)
+		} else {
+			err = dictSet(&d, k, value)
+		}
+		if err != nil {
 			d.AvDictFree()
+			d = nil
 			return
 		}
 	}
 	return
 }
+
+// GetKeysFromAVDictionary return all keys of d in insertion order
+func GetKeysFromAVDictionary(d *libavutil.AvDictionary) (keys []string) {
+	var entry *libavutil.AvDictionaryEntry
+	for {
+		// Iterate all entries by matching empty key with AV_DICT_IGNORE_SUFFIX
+		if entry = d.AvDictGet("", entry, libavutil.AvDictIgnoreSuffix); entry == nil {
+			return
+		}
+		keys = append(keys, entry.Key())
+	}
+}
+
+// dictSet set key/value into *pd, allocating the dictionary if *pd is nil.
+// libavutil.AvDictionary.AvDictSet can not be used here because it takes the
+// dictionary by value and loses the newly allocated one.
+func dictSet(pd **libavutil.AvDictionary, key, value string) error {
+	cKey := C.CString(key)
+	defer C.free(unsafe.Pointer(cKey))
+	cValue := C.CString(value)
+	defer C.free(unsafe.Pointer(cValue))
+
+	if ret := C.av_dict_set((**C.AVDictionary)(unsafe.Pointer(pd)), cKey, cValue, 0); ret < 0 {
+		return averror.New(fmt.Sprintf("GetAVDictionaryFromMap: set key(%v)", key), int(ret))
+	}
+	return nil
+}
+
+// GetMapFromAVDictionary convert libavutil.Dictionary to map, nil if d is empty
+func GetMapFromAVDictionary(d *libavutil.AvDictionary) (m map[string]string) {
+	var entry *libavutil.AvDictionaryEntry
+	for {
+		if entry = d.AvDictGet("", entry, libavutil.AvDictIgnoreSuffix); entry == nil {
+			return
+		}
+		if m == nil {
+			m = make(map[string]string)
+		}
+		m[entry.Key()] = entry.Value()
+	}
+}